@@ -2,56 +2,11 @@ package TF2RconWrapper
 
 import (
 	"errors"
-	"reflect"
-	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
-const (
-	// "Username<userId><steamId><Team>"
-	// "1<2><3><4>" <- regex group
-	logLineStart = `^"(.*)<(\d+)><(\[U:1:\d+\])><(\w+)>" `
-	player       = `"(.*)<(\d+)><(\[U:1:\d+\])><(\w+)>"`
-	// "5" <- regex group
-	logLineEnd = ` "(.*)"`
-
-	logLineStartSpec = `^"(.*)<(\d+)><(\[U:1:\d+\])><(\w*)>" `
-)
-
-// regexes used in the parser
-var (
-	rPlayerGlobalMessage  = regexp.MustCompile(logLineStart + `say` + logLineEnd)
-	rPlayerChangedClass   = regexp.MustCompile(logLineStart + `changed role to "(scout|soldier|pyro|engineer|heavyweapons|demoman|sniper|medic|spy)"`)
-	rPlayerTeamMessage    = regexp.MustCompile(logLineStart + `say_team` + logLineEnd)
-	rPlayerChangedTeam    = regexp.MustCompile(logLineStart + `joined team` + logLineEnd)
-	rPlayerPickedUp       = regexp.MustCompile(logLineStart + `picked up item "(\w+)"(?: \(healing "(\d+)"\))*`)
-	rPlayerSpawned        = regexp.MustCompile(logLineStart + `spawned as "(\w+)"`)
-	rPlayerKilled         = regexp.MustCompile(logLineStart + `killed ` + player + ` with "(\w+)"(?: \(customkill "(\w+)"\)){0,1} \(attacker_position (".+")\) \(victim_position (".+")\)`)
-	rPlayerDamage         = regexp.MustCompile(logLineStart + `triggered "damage" against ` + player + ` \(damage "(\d+)"\)(?: \(realdamage "\d+"\)){0,1} \(weapon "(\w+)"\)(?: \((airshot) "1"\)){0,1}(?: \((headshot) "1"\)){0,1}`)
-	rPlayerHeal           = regexp.MustCompile(logLineStart + `triggered "healed" against ` + player + ` \(healing "(\d+)"\)`)
-	rPlayerKilledMedic    = regexp.MustCompile(logLineStart + `triggered "medic_death" against ` + player + ` \(healing "(\d+)"\) \(ubercharge "(\d+)"\)`)
-	rPlayerUberFinished   = regexp.MustCompile(logLineStart + `triggered "empty_uber"`)
-	rPlayerBlockedCapture = regexp.MustCompile(logLineStart + `triggered "captureblocked" \(cp "(\d+)"\) \(cpname "(#\w+)"\) \(position "(.+)"\)`)
-	rPlayerConnected      = regexp.MustCompile(logLineStartSpec + `connected, address "\d+.\d+.\d+.\d+\:\d+"`)
-	rPlayerDisconnected   = regexp.MustCompile(logLineStartSpec + `disconnected \(reason "(.*)"\)`)
-
-	//Team events
-	rTeamPointCapture = regexp.MustCompile(`^Team "(Red|Blue)" triggered "pointcaptured" \(cp "(\d+)"\) \(cpname "(#\w+)"\)`)
-	rTeamScoreUpdate  = regexp.MustCompile(`^Team "(Red|Blue)" current score "(\d+)" with "\d+" players`)
-
-	//World events
-	rGameOver   = regexp.MustCompile(`^World triggered "Game_Over" reason "(.*)"`)
-	rRoundWin   = regexp.MustCompile(`^World triggered "Round_Win" \(winner "(Red|Blue)"\)`)
-	rRoundStart = regexp.MustCompile(`^World triggered "Round_Start"`)
-	rServerCvar = regexp.MustCompile(`^server_cvar: "(.*)" "(.*)"`)
-
-	rTournamentStarted = regexp.MustCompile(`^Tournament mode started\nBlue Team: \w+\nRed Team: \w`)
-	rLogFiledClosed    = regexp.MustCompile("^Log file closed.")
-
-	rRconCommand = regexp.MustCompile(`^rcon from "(.+)": command "(.+)"`)
-)
-
 const (
 	PlayerGlobalMessage = iota
 	PlayerTeamMessage
@@ -115,8 +70,12 @@ type PlayerTrigger struct {
 
 type PlayerKill struct {
 	PlayerTrigger
-	Weapon     string `json:"weapon"`
-	CustomKill string `json:"customkill"`
+	Weapon           string   `json:"weapon"`
+	CustomKill       string   `json:"customkill"`
+	Crit             bool     `json:"crit"`
+	MiniCrit         bool     `json:"minicrit"`
+	AttackerPosition Position `json:"attacker_position"`
+	VictimPosition   Position `json:"victim_position"`
 }
 
 type PlayerDamage struct {
@@ -136,8 +95,8 @@ type PlayerHeal struct {
 type PlayerData struct {
 	Username string `json:"username"`
 
-	SteamId string `json:"steamid"`
-	UserId  string `json:"userid"`
+	SteamId SteamID `json:"steamid"`
+	UserId  string  `json:"userid"`
 
 	Team    string `json:"team"`
 	NewTeam string `json:"newteam"`
@@ -151,9 +110,14 @@ type CvarData struct {
 	Value    string
 }
 
+// ParsedMsg is the result of ParseLine. Event holds the parsed event. Type
+// and Data hold the same event in the form used before Event existed; Type is
+// -1 for events that had no Type constant then.
 type ParsedMsg struct {
 	Type int
 	Data interface{}
+
+	Event Event
 }
 
 var (
@@ -161,102 +125,18 @@ var (
 	ErrInvalidPacket = errors.New("invalid packet")
 )
 
+// CallHandler calls the function in handler matching the parsed event
 func (p *ParsedMsg) CallHandler(handler *EventListener) {
-	var eventhandler interface{}
-	var in []interface{}
-
-	switch p.Type {
-	case PlayerGlobalMessage:
-		d := p.Data.(PlayerData)
-		eventhandler = handler.PlayerGlobalMessage
-		in = []interface{}{d, d.Text}
-	case PlayerTeamMessage:
-		d := p.Data.(PlayerData)
-		eventhandler = handler.PlayerTeamMessage
-		in = []interface{}{d, d.Text}
-	case PlayerChangedClass:
-		d := p.Data.(PlayerData)
-		eventhandler = handler.PlayerClassChanged
-		in = []interface{}{d, d.Class}
-	case PlayerPickedUpItem:
-		eventhandler = handler.PlayerItemPickup
-		in = []interface{}{p.Data.(ItemPickup)}
-	case PlayerSpawned:
-		eventhandler = handler.PlayerSpawned
-		d := p.Data.(PlayerData)
-		in = []interface{}{d, d.Class}
-	case PlayerChangedTeam:
-		d := p.Data.(PlayerData)
-		in = []interface{}{d, d.NewTeam}
-		eventhandler = handler.PlayerTeamChange
-	case PlayerConnected:
-		in = []interface{}{p.Data.(PlayerData)}
-		eventhandler = handler.PlayerConnected
-	case PlayerKilled:
-		in = []interface{}{p.Data.(PlayerKill)}
-		eventhandler = handler.PlayerKilled
-	case PlayerDamaged:
-		in = []interface{}{p.Data.(PlayerDamage)}
-		eventhandler = handler.PlayerDamaged
-	case PlayerHealed:
-		in = []interface{}{p.Data.(PlayerHeal)}
-		eventhandler = handler.PlayerHealed
-	case PlayerKilledMedic:
-		in = []interface{}{p.Data.(PlayerTrigger)}
-		eventhandler = handler.PlayerKilledMedic
-	case PlayerUberFinished:
-		in = []interface{}{p.Data.(PlayerData)}
-		eventhandler = handler.PlayerUberFinished
-	case PlayerBlockedCapture:
-		arr := p.Data.([]interface{})
-		in = []interface{}{arr[0], arr[1]}
-		eventhandler = handler.PlayerBlockedCapture
-	case TeamPointCapture:
-		in = []interface{}{p.Data.(TeamData)}
-		eventhandler = handler.TeamPointCapture
-	case TeamScoreUpdate:
-		in = []interface{}{p.Data.(TeamData)}
-		eventhandler = handler.TeamScoreUpdate
-	case WorldRoundWin:
-		in = []interface{}{p.Data.(string)}
-		eventhandler = handler.WorldRoundWin
-	//case WorldRoundStart:
-	case PlayerDisconnected:
-		in = []interface{}{p.Data.(PlayerData)}
-		eventhandler = handler.PlayerDisconnected
-	case WorldGameOver:
-		eventhandler = handler.GameOver
-	case ServerCvar:
-		in = []interface{}{p.Data.(CvarData).Value, p.Data.(CvarData).Variable}
-		eventhandler = handler.CVarChange
-	case LogFileClosed:
-		eventhandler = handler.LogFileClosed
-	case TournamentStarted:
-		eventhandler = handler.TournamentStarted
-	case RconCommand:
-		arr := p.Data.([]string)
-		in = []interface{}{arr[0], arr[1]}
-		eventhandler = handler.RconCommand
-	default:
-		return
-	}
-
-	var args []reflect.Value
-	for _, arg := range in {
-		args = append(args, reflect.ValueOf(arg))
+	if p.Event != nil {
+		handler.HandleEvent(p.Event)
 	}
-
-	method := reflect.ValueOf(eventhandler)
-	if !method.IsValid() || method.IsNil() {
-		return
-	}
-	method.Call(args)
 }
 
 /**
 Log Message layout:
 First Four Bytes: 0xff, 0xff, 0xff, 0xff
 Fifth Byte: 0x53 (ASCII code for 'S', denoting that the log message has a secret prepended)
+            or 0x52 ('R', a log message without a secret, see getUnsecretedLine)
 Text between 'S' and 'L': log secret, can be of variable length.
 Text after (and including L):
 L 01/02/2006 -  15:04:05: <log message>
@@ -292,224 +172,751 @@ func getSecret(data []byte) (string, int, error) {
 	return secret, 5 + Lpos, nil
 }
 
+//getUnsecretedLine returns the position of the log message in a packet
+//sent without a secret, where it follows the 'R' directly
+func getUnsecretedLine(data []byte) (int, error) {
+	if len(data) <= 6+len(" 03/09/2016 - 02:50:52:") || data[4] != 0x52 || data[5] != 0x4C {
+		return 0, ErrInvalidPacket
+	}
+	return 5, nil
+}
+
 //TimeFormat is the reference time used by the server
 //to represent time
 const TimeFormat = "01/02/2006 -  15:04:05"
 
+//logEntryPrefix is the shape of the start of every log entry, with 0
+//standing for any digit
+const logEntryPrefix = "L 00/00/0000 - 00:00:00: "
+
+//LogEntryError is returned by ParseLogEntryE for lines that aren't log
+//entries, or whose timestamp is invalid
+type LogEntryError struct {
+	Line   string
+	Reason string
+	Err    error //the time.Parse error for invalid timestamps
+}
+
+func (e *LogEntryError) Error() string {
+	if e.Err != nil {
+		return "invalid log entry: " + e.Reason + ": " + e.Err.Error()
+	}
+	return "invalid log entry: " + e.Reason
+}
+
+func (e *LogEntryError) Unwrap() error {
+	return e.Err
+}
+
 //ParseLogEntry parses a log entry of the format:
 //        L 03/09/2016 - 02:50:52: <log message>\n\0
-//and returns a LogMessage object
+//and returns a LogMessage object. Timestamps are read as UTC. Lines that
+//aren't log entries give a LogMessage with no message and a Parsed.Type of
+//-1; ParseLogEntryE reports why.
 func ParseLogEntry(line string) LogMessage {
-	timeStr := line[2:23]
-	message := line[25:]
-
-	timeObj, _ := time.Parse(TimeFormat, timeStr)
-	return LogMessage{timeObj, message, ParseLine(message)}
+	m, _ := ParseLogEntryE(line, time.UTC)
+	return m
 }
 
-func getPlayerData(matches []string, from int, includeTeam bool) PlayerData {
-	d := PlayerData{
-		Username: matches[from+0],
-		UserId:   matches[from+1],
-		SteamId:  matches[from+2],
+//ParseLogEntryE parses a log entry like ParseLogEntry, reading its timestamp
+//in loc, the server's time zone. A nil loc means UTC.
+//
+//If the line doesn't start like a log entry the error is a *LogEntryError
+//and the LogMessage is empty. If only the timestamp is invalid, the message
+//is still parsed and returned with a zero Timestamp.
+func ParseLogEntryE(line string, loc *time.Location) (LogMessage, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	if len(line) < len(logEntryPrefix) {
+		return LogMessage{Parsed: ParsedMsg{Type: -1}}, &LogEntryError{Line: line, Reason: "too short"}
+	}
+	for i := 0; i < len(logEntryPrefix); i++ {
+		if logEntryPrefix[i] == '0' && !isDigits(line[i:i+1]) ||
+			logEntryPrefix[i] != '0' && line[i] != logEntryPrefix[i] {
+			return LogMessage{Parsed: ParsedMsg{Type: -1}},
+				&LogEntryError{Line: line, Reason: "malformed prefix at byte " + strconv.Itoa(i)}
+		}
 	}
 
-	if includeTeam {
-		d.Team = matches[from+3]
+	message := line[len(logEntryPrefix):]
+	m := LogMessage{Message: message, Parsed: ParseLine(message)}
+
+	timestamp, err := time.ParseInLocation(TimeFormat, line[2:23], loc)
+	if err != nil {
+		return m, &LogEntryError{Line: line, Reason: "invalid timestamp", Err: err}
 	}
+	m.Timestamp = timestamp
 
-	return d
+	return m, nil
 }
 
 //ParseLine parses a log message (without the time entry)
 func ParseLine(message string) ParsedMsg {
-	r := ParsedMsg{Type: -1}
+	e := ParseEvent(message)
+	if e == nil {
+		return ParsedMsg{Type: -1}
+	}
+
+	typ, data := e.legacy()
+	return ParsedMsg{Type: typ, Data: data, Event: e}
+}
+
+//ParseEvent parses a log message (without the time entry) into one of the
+//*Event types. Messages without an event type of their own are returned as
+//an UnknownEvent.
+func ParseEvent(message string) Event {
+	if e := parseKnownEvent(message); e != nil {
+		return e
+	}
+
+	return parseUnknownEvent(message)
+}
+
+// parseKnownEvent returns nil if message isn't one of the modelled events
+func parseKnownEvent(message string) Event {
+	sc := logScanner{s: message}
+
+	if player, ok := sc.player(); ok {
+		return parsePlayerEvent(player, &sc)
+	}
 
-	switch {
-	case rPlayerKilled.MatchString(message):
-		m := rPlayerKilled.FindStringSubmatch(message)
+	switch word := sc.word(); word {
+	case "World":
+		if !sc.expect("triggered") {
+			return nil
+		}
+		return parseWorldEvent(&sc)
+
+	case "Team":
+		team, ok := sc.quoted()
+		if !ok {
+			return nil
+		}
+		return parseTeamEvent(team, &sc)
+
+	case "server_cvar:":
+		variable, ok1 := sc.quoted()
+		value, ok2 := sc.quoted()
+		if ok1 && ok2 {
+			return ServerCvarEvent{CvarData: CvarData{Variable: variable, Value: value}}
+		}
+
+	case "rcon":
+		if !sc.expect("from") {
+			return nil
+		}
+		from, ok := sc.quoted()
+		if !ok || !sc.expect(":") || !sc.expect("command") {
+			return nil
+		}
+		if command, ok := sc.restQuoted(); ok && command != "" {
+			return RconCommandEvent{From: from, Command: command}
+		}
+
+	case "Log":
+		if !sc.expect("file") {
+			return nil
+		}
+		if strings.HasPrefix(sc.rest(), " closed") {
+			return LogFileClosedEvent{}
+		}
+		if sc.expect("started") {
+			var propsBuf [8]Property
+			props := sc.properties(propsBuf[:0])
+			e := LogFileStartedEvent{LogProperties: props.logProperties()}
+			e.File, _ = props.Get("file")
+			e.Game, _ = props.Get("game")
+			e.Version, _ = props.Get("version")
+			return e
+		}
+
+	case "Loading":
+		if !sc.expect("map") {
+			return nil
+		}
+		if name, ok := sc.quoted(); ok {
+			return MapLoadingEvent{Map: name}
+		}
 
+	case "Started":
+		if !sc.expect("map") {
+			return nil
+		}
+		if name, ok := sc.quoted(); ok {
+			var propsBuf [8]Property
+			props := sc.properties(propsBuf[:0])
+			crc, _ := props.Get("CRC")
+			return MapStartedEvent{
+				LogProperties: props.logProperties(),
+				Map:           name,
+				CRC:           crc,
+			}
+		}
+
+	case "Tournament":
+		// servers log this over three lines, "Tournament mode started",
+		// "Blue Team: name" and "Red Team: name", which a Source puts
+		// back together. Messages holding all three are parsed in one go.
+		lines := strings.Split(message, "\n")
+		if lines[0] != "Tournament mode started" {
+			return nil
+		}
+		var e TournamentStartedEvent
+		for _, line := range lines[1:] {
+			if team, ok := parseKnownEvent(line).(TournamentTeamEvent); ok {
+				e.setTeamName(team)
+			}
+		}
+		return e
+
+	case "Blue", "Red":
+		if sc.expect("Team:") {
+			return TournamentTeamEvent{Team: word, Name: strings.TrimSpace(sc.rest())}
+		}
+	}
+
+	return nil
+}
+
+var classes = map[string]bool{
+	"scout":        true,
+	"soldier":      true,
+	"pyro":         true,
+	"engineer":     true,
+	"heavyweapons": true,
+	"demoman":      true,
+	"sniper":       true,
+	"medic":        true,
+	"spy":          true,
+}
+
+// parsePlayerEvent parses the rest of a message whose subject is player
+func parsePlayerEvent(player PlayerData, sc *logScanner) Event {
+	var propsBuf [8]Property
+
+	switch sc.word() {
+	case "say":
+		if text, ok := sc.restQuoted(); ok {
+			return PlayerGlobalMessageEvent{Player: player, Text: text}
+		}
+
+	case "say_team":
+		if text, ok := sc.restQuoted(); ok {
+			return PlayerTeamMessageEvent{Player: player, Text: text}
+		}
+
+	case "changed":
+		if !sc.expect("role") || !sc.expect("to") {
+			return nil
+		}
+		if class, ok := sc.quoted(); ok && classes[class] {
+			props := sc.properties(propsBuf[:0])
+			return PlayerChangedClassEvent{
+				LogProperties: props.logProperties(),
+				Player:        player,
+				Class:         class,
+			}
+		}
+
+	case "joined":
+		if !sc.expect("team") {
+			return nil
+		}
+		if team, ok := sc.restQuoted(); ok {
+			return PlayerChangedTeamEvent{Player: player, NewTeam: team}
+		}
+
+	case "spawned":
+		if !sc.expect("as") {
+			return nil
+		}
+		if class, ok := sc.quoted(); ok && isWord(class) {
+			props := sc.properties(propsBuf[:0])
+			return PlayerSpawnedEvent{
+				LogProperties: props.logProperties(),
+				Player:        player,
+				Class:         class,
+			}
+		}
+
+	case "picked":
+		if !sc.expect("up") || !sc.expect("item") {
+			return nil
+		}
+		item, ok := sc.quoted()
+		if !ok || !isWord(item) {
+			return nil
+		}
+
+		props := sc.properties(propsBuf[:0])
+		pickup := ItemPickup{PlayerData: player, Item: item}
+		pickup.Healing, _ = props.Int("healing")
+		return PlayerPickedUpItemEvent{
+			LogProperties: props.logProperties(),
+			ItemPickup:    pickup,
+		}
+
+	case "killed":
+		victim, ok := sc.player()
+		if !ok || !sc.expect("with") {
+			return nil
+		}
+		weapon, ok := sc.quoted()
+		if !ok || !isWord(weapon) {
+			return nil
+		}
+
+		props := sc.properties(propsBuf[:0])
 		kill := PlayerKill{
-			PlayerTrigger: PlayerTrigger{
-				Player1: getPlayerData(m, 1, true),
-				Player2: getPlayerData(m, 5, true),
-			},
+			PlayerTrigger: PlayerTrigger{player, victim},
+			Weapon:        weapon,
 		}
+		kill.CustomKill, _ = props.Get("customkill")
+		crit, _ := props.Get("crit")
+		kill.Crit = crit == "crit"
+		kill.MiniCrit = crit == "mini"
+		kill.AttackerPosition, _ = props.Position("attacker_position")
+		kill.VictimPosition, _ = props.Position("victim_position")
+		return PlayerKilledEvent{
+			LogProperties: props.logProperties(),
+			PlayerKill:    kill,
+		}
+
+	case "triggered":
+		return parsePlayerTrigger(player, sc, propsBuf[:0])
 
-		kill.Weapon = m[9]
-		if len(m) > 10 {
-			kill.CustomKill = m[10]
+	case "committed":
+		if !sc.expect("suicide") || !sc.expect("with") {
+			return nil
+		}
+		weapon, ok := sc.quoted()
+		if !ok {
+			return nil
 		}
 
-		r.Data = kill
-		r.Type = PlayerKilled
+		props := sc.properties(propsBuf[:0])
+		position, _ := props.Position("attacker_position")
+		return PlayerSuicideEvent{
+			LogProperties: props.logProperties(),
+			Player:        player,
+			Weapon:        weapon,
+			Position:      position,
+		}
 
-	case rPlayerDamage.MatchString(message):
-		m := rPlayerDamage.FindStringSubmatch(message)
+	case "connected,":
+		if !sc.expect("address") {
+			return nil
+		}
+		if addr, ok := sc.quoted(); ok {
+			props := sc.properties(propsBuf[:0])
+			player.Team = ""
+			return PlayerConnectedEvent{
+				LogProperties: props.logProperties(),
+				Player:        player,
+				Address:       addr,
+			}
+		}
 
-		dmg, _ := strconv.Atoi(m[9])
-		damage := PlayerDamage{
-			PlayerTrigger: PlayerTrigger{
-				Player1: getPlayerData(m, 1, true), // ends at m[4]
-				Player2: getPlayerData(m, 5, true), // ends at m[8]
+	case "disconnected":
+		props := sc.properties(propsBuf[:0])
+		if reason, ok := props.Get("reason"); ok {
+			player.Team = ""
+			return PlayerDisconnectedEvent{
+				LogProperties: props.logProperties(),
+				Player:        player,
+				Reason:        reason,
+			}
+		}
+	}
+
+	return nil
+}
+
+// parsePlayerTrigger parses `triggered "name" [against "player"] (key "value")...`
+func parsePlayerTrigger(player PlayerData, sc *logScanner, props Properties) Event {
+	trigger, ok := sc.quoted()
+	if !ok {
+		return nil
+	}
+
+	var target PlayerData
+	if sc.expect("against") {
+		if target, ok = sc.player(); !ok {
+			return nil
+		}
+	}
+	props = sc.properties(props)
+
+	switch trigger {
+	case "damage":
+		damage, ok := props.Int("damage")
+		weapon, ok2 := props.Get("weapon")
+		if !ok || !ok2 || target.UserId == "" {
+			return nil
+		}
+
+		airshot, _ := props.Get("airshot")
+		headshot, _ := props.Get("headshot")
+		return PlayerDamagedEvent{
+			LogProperties: props.logProperties(),
+			PlayerDamage: PlayerDamage{
+				PlayerTrigger: PlayerTrigger{player, target},
+				Damage:        damage,
+				Weapon:        weapon,
+				Airshot:       airshot == "1",
+				Headshot:      headshot == "1",
 			},
+		}
 
-			Damage:   dmg,
-			Weapon:   m[10],
-			Airshot:  m[11] == "airshot",
-			Headshot: m[12] == "headshot",
+	case "healed":
+		healing, ok := props.Int("healing")
+		if !ok || target.UserId == "" {
+			return nil
+		}
+		return PlayerHealedEvent{
+			LogProperties: props.logProperties(),
+			PlayerHeal:    PlayerHeal{PlayerTrigger{player, target}, healing},
 		}
 
-		r.Data = damage
-		r.Type = PlayerDamaged
+	case "medic_death":
+		healing, ok := props.Int("healing")
+		uber, ok2 := props.Get("ubercharge")
+		if !ok || !ok2 || target.UserId == "" {
+			return nil
+		}
+		return PlayerKilledMedicEvent{
+			LogProperties: props.logProperties(),
+			PlayerTrigger: PlayerTrigger{player, target},
+			Healing:       healing,
+			Ubercharge:    uber == "1",
+		}
 
-	case rPlayerHeal.MatchString(message):
-		m := rPlayerHeal.FindStringSubmatch(message)
+	case "kill assist":
+		if target.UserId == "" {
+			return nil
+		}
+		assist := PlayerKillAssistEvent{
+			LogProperties: props.logProperties(),
+			PlayerTrigger: PlayerTrigger{player, target},
+		}
+		assist.AssisterPosition, _ = props.Position("assister_position")
+		assist.AttackerPosition, _ = props.Position("attacker_position")
+		assist.VictimPosition, _ = props.Position("victim_position")
+		return assist
+
+	case "domination":
+		if target.UserId == "" {
+			return nil
+		}
+		assist, _ := props.Get("assist")
+		return PlayerDominationEvent{
+			LogProperties: props.logProperties(),
+			PlayerTrigger: PlayerTrigger{player, target},
+			Assist:        assist == "1",
+		}
 
-		healing, _ := strconv.Atoi(m[9])
-		heal := PlayerHeal{
-			PlayerTrigger: PlayerTrigger{
-				Player1: getPlayerData(m, 1, true), // end at m[4]
-				Player2: getPlayerData(m, 5, true), // ends at m[8]
-			},
-			Healed: healing,
+	case "revenge":
+		if target.UserId == "" {
+			return nil
+		}
+		assist, _ := props.Get("assist")
+		return PlayerRevengeEvent{
+			LogProperties: props.logProperties(),
+			PlayerTrigger: PlayerTrigger{player, target},
+			Assist:        assist == "1",
 		}
-		r.Data = heal
-		r.Type = PlayerHealed
 
-	case rPlayerPickedUp.MatchString(message):
-		m := rPlayerPickedUp.FindStringSubmatch(message)
+	case "empty_uber":
+		return PlayerUberFinishedEvent{
+			LogProperties: props.logProperties(),
+			Player:        player,
+		}
 
-		playerData := getPlayerData(m, 1, true)
-		pickup := ItemPickup{
-			PlayerData: playerData,
-			Item:       m[5],
+	case "chargeready":
+		return PlayerChargeReadyEvent{
+			LogProperties: props.logProperties(),
+			Player:        player,
 		}
 
-		if len(m) == 7 {
-			pickup.Healing, _ = strconv.Atoi(m[6])
+	case "chargedeployed":
+		medigun, _ := props.Get("medigun")
+		return PlayerChargeDeployedEvent{
+			LogProperties: props.logProperties(),
+			Player:        player,
+			Medigun:       medigun,
 		}
 
-		r.Data = pickup
-		r.Type = PlayerPickedUpItem
+	case "chargeended":
+		if duration, ok := props.Duration("duration"); ok {
+			return PlayerChargeEndedEvent{
+				LogProperties: props.logProperties(),
+				Player:        player,
+				Duration:      duration,
+			}
+		}
 
-	case rPlayerGlobalMessage.MatchString(message):
-		m := rPlayerGlobalMessage.FindStringSubmatch(message)
+	case "first_heal_after_spawn":
+		if elapsed, ok := props.Duration("time"); ok {
+			return PlayerFirstHealEvent{
+				LogProperties: props.logProperties(),
+				Player:        player,
+				Time:          elapsed,
+			}
+		}
 
-		playerData := getPlayerData(m, 1, true)
-		playerData.Text = m[5]
-		r.Data = playerData
-		r.Type = PlayerGlobalMessage
+	case "lost_uber_advantage":
+		if elapsed, ok := props.Duration("time"); ok {
+			return PlayerLostUberAdvantageEvent{
+				LogProperties: props.logProperties(),
+				Player:        player,
+				Time:          elapsed,
+			}
+		}
 
-	case rPlayerTeamMessage.MatchString(message):
-		m := rPlayerTeamMessage.FindStringSubmatch(message)
+	case "player_builtobject", "player_carryobject", "player_dropobject", "object_detonated":
+		name, _ := props.Get("object")
+		object, ok := ParseObject(name)
+		if !ok {
+			return nil
+		}
 
-		playerData := getPlayerData(m, 1, true)
-		playerData.Text = m[5]
-		r.Data = playerData
-		r.Type = PlayerTeamMessage
+		lp := props.logProperties()
+		position, _ := props.Position("position")
+		switch trigger {
+		case "player_builtobject":
+			return PlayerBuiltObjectEvent{
+				LogProperties: lp,
+				Player:        player,
+				Object:        object,
+				Position:      position,
+			}
+		case "player_carryobject":
+			return PlayerCarriedObjectEvent{
+				LogProperties: lp,
+				Player:        player,
+				Object:        object,
+				Position:      position,
+			}
+		case "player_dropobject":
+			return PlayerDroppedObjectEvent{
+				LogProperties: lp,
+				Player:        player,
+				Object:        object,
+				Position:      position,
+			}
+		default:
+			return ObjectDetonatedEvent{
+				LogProperties: lp,
+				Player:        player,
+				Object:        object,
+				Position:      position,
+			}
+		}
 
-	case rPlayerChangedClass.MatchString(message):
-		m := rPlayerChangedClass.FindStringSubmatch(message)
+	case "killedobject":
+		name, _ := props.Get("object")
+		object, ok := ParseObject(name)
+		owner, _ := props.Get("objectowner")
+		ownerData, ok2 := splitPlayer(owner)
+		if !ok || !ok2 {
+			return nil
+		}
 
-		playerData := getPlayerData(m, 1, true)
-		playerData.Class = m[5]
-		r.Data = playerData
-		r.Type = PlayerChangedClass
+		e := PlayerKilledObjectEvent{
+			LogProperties: props.logProperties(),
+			Player:        player,
+			Owner:         ownerData,
+			Object:        object,
+		}
+		e.Weapon, _ = props.Get("weapon")
+		assist, _ := props.Get("assist")
+		e.Assist = assist == "1"
+		e.AttackerPosition, _ = props.Position("attacker_position")
+		return e
+
+	case "captureblocked":
+		cp, ok1 := props.Get("cp")
+		cpname, ok2 := props.Get("cpname")
+		position, ok3 := props.Position("position")
+		if ok1 && ok2 && ok3 {
+			return PlayerBlockedCaptureEvent{
+				LogProperties: props.logProperties(),
+				CPData:        CPData{cp, cpname},
+				Player:        player,
+				Position:      position,
+			}
+		}
+	}
+
+	return nil
+}
 
-	case rPlayerChangedTeam.MatchString(message):
-		m := rPlayerChangedTeam.FindStringSubmatch(message)
+// parseWorldEvent parses the rest of a `World triggered` message
+func parseWorldEvent(sc *logScanner) Event {
+	var propsBuf [8]Property
+
+	name, ok := sc.quoted()
+	if !ok {
+		return nil
+	}
 
-		playerData := getPlayerData(m, 1, true)
-		playerData.NewTeam = m[5]
-		r.Data = playerData
-		r.Type = PlayerChangedTeam
+	switch name {
+	case "Game_Over":
+		if !sc.expect("reason") {
+			return nil
+		}
+		if reason, ok := sc.restQuoted(); ok {
+			return WorldGameOverEvent{Reason: reason}
+		}
+
+	case "Round_Win":
+		props := sc.properties(propsBuf[:0])
+		if winner, ok := props.Get("winner"); ok {
+			return WorldRoundWinEvent{
+				LogProperties: props.logProperties(),
+				Winner:        winner,
+			}
+		}
 
-	case rPlayerSpawned.MatchString(message):
-		m := rPlayerSpawned.FindStringSubmatch(message)
-		playerData := getPlayerData(m, 1, true)
-		playerData.Class = m[5]
-		r.Data = playerData
-		r.Type = PlayerSpawned
+	case "Round_Length":
+		props := sc.properties(propsBuf[:0])
+		if length, ok := props.Duration("seconds"); ok {
+			return WorldRoundLengthEvent{
+				LogProperties: props.logProperties(),
+				Length:        length,
+			}
+		}
 
-	case rPlayerKilledMedic.MatchString(message):
-		m := rPlayerKilledMedic.FindStringSubmatch(message)
+	case "Intermission_Win_Limit":
+		props := sc.properties(propsBuf[:0])
+		return WorldIntermissionWinLimitEvent{LogProperties: props.logProperties()}
 
-		r.Data = PlayerTrigger{
-			Player1: getPlayerData(m, 1, true),
-			Player2: getPlayerData(m, 5, true),
+	default:
+		props := sc.properties(propsBuf[:0])
+		lp := props.logProperties()
+		switch name {
+		case "Round_Start":
+			return WorldRoundStartEvent{lp}
+		case "Round_Setup_Begin":
+			return WorldRoundSetupBeginEvent{lp}
+		case "Round_Setup_End":
+			return WorldRoundSetupEndEvent{lp}
+		case "Round_Overtime":
+			return WorldRoundOvertimeEvent{lp}
+		case "Round_Stalemate":
+			return WorldRoundStalemateEvent{lp}
+		case "Game_Paused":
+			return WorldGamePausedEvent{lp}
+		case "Game_Unpaused":
+			return WorldGameUnpausedEvent{lp}
 		}
-		r.Type = PlayerKilledMedic
+	}
 
-	case rPlayerUberFinished.MatchString(message):
-		m := rPlayerUberFinished.FindStringSubmatch(message)
+	return nil
+}
 
-		r.Data = getPlayerData(m, 1, true)
-		r.Type = PlayerUberFinished
+// parseTeamEvent parses the rest of a `Team "name"` message
+func parseTeamEvent(team string, sc *logScanner) Event {
+	var propsBuf [8]Property
 
-	case rPlayerBlockedCapture.MatchString(message):
-		m := rPlayerBlockedCapture.FindStringSubmatch(message)
+	switch verb := sc.word(); verb {
+	case "triggered":
+		trigger, ok := sc.quoted()
+		if !ok {
+			return nil
+		}
+		props := sc.properties(propsBuf[:0])
 
-		r.Data = []interface{}{CPData{m[5], m[6]}, getPlayerData(m, 1, true)}
+		if trigger == "Intermission_Win_Limit" {
+			return WorldIntermissionWinLimitEvent{
+				LogProperties: props.logProperties(),
+				Team:          team,
+			}
+		}
+		if trigger != "pointcaptured" {
+			return nil
+		}
 
-		r.Type = PlayerBlockedCapture
+		cp, ok1 := props.Get("cp")
+		cpname, ok2 := props.Get("cpname")
+		if ok1 && ok2 {
+			return TeamPointCaptureEvent{
+				LogProperties: props.logProperties(),
+				CPData:        CPData{cp, cpname},
+				Team:          team,
+			}
+		}
 
-	case rPlayerConnected.MatchString(message):
-		m := rPlayerConnected.FindStringSubmatch(message)
+	case "current", "final":
+		if !sc.expect("score") {
+			return nil
+		}
+		score, ok1 := sc.quotedInt()
+		if !sc.expect("with") {
+			return nil
+		}
+		players, ok2 := sc.quotedInt()
+		if !ok1 || !ok2 || !sc.expect("players") {
+			return nil
+		}
 
-		playerData := getPlayerData(m, 1, false)
-		r.Data = playerData
-		r.Type = PlayerConnected
+		if verb == "final" {
+			return TeamFinalScoreEvent{
+				Team:    team,
+				Score:   score,
+				Players: players,
+			}
+		}
+		return TeamScoreUpdateEvent{
+			Team:    team,
+			Score:   score,
+			Players: players,
+		}
+	}
 
-	case rPlayerDisconnected.MatchString(message):
-		m := rPlayerDisconnected.FindStringSubmatch(message)
+	return nil
+}
 
-		playerData := getPlayerData(m, 1, false)
-		r.Data = playerData
-		r.Type = PlayerDisconnected
+// parseUnknownEvent decodes as much of the common message grammar as message
+// follows
+func parseUnknownEvent(message string) UnknownEvent {
+	e := UnknownEvent{Message: message}
+	sc := logScanner{s: message}
 
-	// Non-Player Messages
-	case rGameOver.MatchString(message):
-		r.Type = WorldGameOver
+	if player, ok := sc.player(); ok {
+		e.Player = &player
+		e.Verb = sc.word()
+	}
+
+	var props Properties
+	for {
+		sc.skipSpaces()
+		if sc.pos >= len(sc.s) {
+			break
+		}
 
-	case rRoundWin.MatchString(message):
-		m := rRoundWin.FindStringSubmatch(message)
-		r.Data = m[1]
-		r.Type = WorldRoundWin
+		switch sc.s[sc.pos] {
+		case '(':
+			start := sc.pos
+			if props = sc.properties(props); sc.pos > start {
+				continue
+			}
+		case '"':
+			if arg, ok := sc.quoted(); ok {
+				if target, ok := splitPlayer(arg); ok && e.Player != nil && e.Target == nil {
+					e.Target = &target
+				} else {
+					e.Args = append(e.Args, arg)
+				}
+				continue
+			}
+		}
 
-	case rServerCvar.MatchString(message):
-		m := rServerCvar.FindStringSubmatch(message)
-		r.Type = ServerCvar
-		r.Data = CvarData{Variable: m[1], Value: m[2]}
-
-	case rLogFiledClosed.MatchString(message):
-		r.Type = LogFileClosed
-	case rTournamentStarted.MatchString(message):
-		r.Type = TournamentStarted
-	case rTeamPointCapture.MatchString(message):
-		m := rTeamPointCapture.FindStringSubmatch(message)
-		team := TeamData{
-			Team: m[1],
-			CPData: CPData{
-				CP:     m[2],
-				CPName: m[3],
-			}}
-		r.Data = team
-		r.Type = TeamPointCapture
-	case rTeamScoreUpdate.MatchString(message):
-		m := rTeamScoreUpdate.FindStringSubmatch(message)
-		team := TeamData{
-			Team:  m[1],
-			Score: m[2],
-		}
-		r.Data = team
-		r.Type = TeamScoreUpdate
-
-	case rRconCommand.MatchString(message):
-		m := rRconCommand.FindStringSubmatch(message)
-		r.Data = []string{m[1], m[2]}
-		r.Type = RconCommand
+		e.Args = append(e.Args, sc.word())
 	}
 
-	return r
+	e.Props = props
+	return e
 }
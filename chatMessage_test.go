@@ -1,7 +1,9 @@
 package TF2RconWrapper
 
 import (
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -47,7 +49,7 @@ func TestParse(t *testing.T) {
 
 			assert.Equal(t, playerData.Username, "Sk1LL0")
 			assert.Equal(t, playerData.UserId, "2")
-			assert.Equal(t, playerData.SteamId, "[U:1:198288660]")
+			assert.Equal(t, playerData.SteamId.String(), "[U:1:198288660]")
 
 			// 1 = changed class
 		case 1:
@@ -131,7 +133,7 @@ func TestParse(t *testing.T) {
 			assert.Equal(t, damage.Player2, PlayerData{
 				Username: "beastie",
 				UserId:   "5",
-				SteamId:  "[U:1:28701225]",
+				SteamId:  mustParseSteamID("[U:1:28701225]"),
 				Team:     "Red",
 			})
 			assert.Equal(t, damage.Damage, 100)
@@ -144,7 +146,7 @@ func TestParse(t *testing.T) {
 			assert.Equal(t, damage.Player2, PlayerData{
 				Username: "≫HarZe",
 				UserId:   "3",
-				SteamId:  "[U:1:40572775]",
+				SteamId:  mustParseSteamID("[U:1:40572775]"),
 				Team:     "Blue",
 			})
 			assert.Equal(t, damage.Damage, 43)
@@ -172,3 +174,448 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseEvent(t *testing.T) {
+	e := ParseEvent(logs[0])
+	require.IsType(t, PlayerChangedTeamEvent{}, e)
+	assert.Equal(t, PlayerChangedTeamEvent{
+		Player: PlayerData{
+			Username: "Sk1LL0",
+			UserId:   "2",
+			SteamId:  mustParseSteamID("[U:1:198288660]"),
+			Team:     "Unassigned",
+		},
+		NewTeam: "Red",
+	}, e)
+
+	switch e := ParseEvent(logs[16]).(type) {
+	case PlayerKilledMedicEvent:
+		assert.Equal(t, "crab_f ring plz", e.Player2.Username)
+		assert.Equal(t, 802, e.Healing)
+		assert.False(t, e.Ubercharge)
+	default:
+		t.Fatalf("expected PlayerKilledMedicEvent, got %T", e)
+	}
+
+	assert.Equal(t, PlayerBlockedCaptureEvent{
+		LogProperties: LogProperties{Properties{
+			{"cp", "0"},
+			{"cpname", "#koth_viaduct_cap"},
+			{"position", "-1727 -405 192"},
+		}},
+		CPData:   CPData{"0", "#koth_viaduct_cap"},
+		Player:   PlayerData{Username: "Slappy™", UserId: "11", SteamId: mustParseSteamID("[U:1:56973094]"), Team: "Blue"},
+		Position: Position{-1727, -405, 192},
+	}, ParseEvent(logs[20]))
+	assert.Equal(t, RconCommandEvent{
+		From:    "176.9.138.143:50647",
+		Command: "sv_logflush 1; tv_stoprecord; kickall Reservation ended, every player can download the STV demo at http:/​/serveme.tf",
+	}, ParseEvent(logs[21]))
+
+	assert.Equal(t, -1, ParseLine(`garbage`).Type)
+}
+
+func TestParseUnknownEvent(t *testing.T) {
+	player := PlayerData{Username: "Sk1LL0", UserId: "2", SteamId: mustParseSteamID("[U:1:198288660]"), Team: "Red"}
+	target := PlayerData{Username: "mu", UserId: "12", SteamId: mustParseSteamID("[U:1:33573908]"), Team: "Blue"}
+
+	message := `"Sk1LL0<2><[U:1:198288660]><Red>" changed name to "Sk1LL0 (lobby)"`
+	assert.Equal(t, UnknownEvent{
+		Message: message,
+		Player:  &player,
+		Verb:    "changed",
+		Args:    []string{"name", "to", "Sk1LL0 (lobby)"},
+	}, ParseEvent(message))
+
+	message = `"Sk1LL0<2><[U:1:198288660]><Red>" triggered "sm_slap" against "mu<12><[U:1:33573908]><Blue>" (damage "5")`
+	assert.Equal(t, UnknownEvent{
+		LogProperties: LogProperties{Properties{{"damage", "5"}}},
+		Message:       message,
+		Player:        &player,
+		Verb:          "triggered",
+		Target:        &target,
+		Args:          []string{"sm_slap", "against"},
+	}, ParseEvent(message))
+
+	message = `World triggered "Custom_Vote_Passed" (seconds "339.63")`
+	assert.Equal(t, UnknownEvent{
+		LogProperties: LogProperties{Properties{{"seconds", "339.63"}}},
+		Message:       message,
+		Args:          []string{"World", "triggered", "Custom_Vote_Passed"},
+	}, ParseEvent(message))
+
+	var unhandled []string
+	handler := &EventListener{Unhandled: func(e UnknownEvent) {
+		unhandled = append(unhandled, e.Message)
+	}}
+	m := ParseLine(message)
+	assert.Equal(t, -1, m.Type)
+	m.CallHandler(handler)
+	assert.Equal(t, []string{message}, unhandled)
+}
+
+func TestParseKillEvents(t *testing.T) {
+	lyreix := PlayerData{Username: "Lyreix | TF2Stadium.com", UserId: "4", SteamId: mustParseSteamID("[U:1:56108026]"), Team: "Blue"}
+	kaidus := PlayerData{Username: "kaidus", UserId: "7", SteamId: mustParseSteamID("[U:1:45115290]"), Team: "Red"}
+
+	e := ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" killed "kaidus<7><[U:1:45115290]><Red>" with "crusaders_crossbow" (crit "mini") (attacker_position "1070 -1734 348") (victim_position "-795 -663 517")`)
+	require.IsType(t, PlayerKilledEvent{}, e)
+	kill := e.(PlayerKilledEvent).PlayerKill
+	assert.False(t, kill.Crit)
+	assert.True(t, kill.MiniCrit)
+	assert.Equal(t, Position{1070, -1734, 348}, kill.AttackerPosition)
+	assert.Equal(t, Position{-795, -663, 517}, kill.VictimPosition)
+
+	kill = ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" killed "kaidus<7><[U:1:45115290]><Red>" with "ubersaw" (crit "crit") (attacker_position "1 2 3") (victim_position "4 5 6")`).(PlayerKilledEvent).PlayerKill
+	assert.True(t, kill.Crit)
+	assert.False(t, kill.MiniCrit)
+
+	e = ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" triggered "kill assist" against "kaidus<7><[U:1:45115290]><Red>" (assister_position "2707 -13 520") (attacker_position "1070 -1734 348") (victim_position "-795 -663 517")`)
+	require.IsType(t, PlayerKillAssistEvent{}, e)
+	assist := e.(PlayerKillAssistEvent)
+	assert.Equal(t, PlayerTrigger{lyreix, kaidus}, assist.PlayerTrigger)
+	assert.Equal(t, Position{2707, -13, 520}, assist.AssisterPosition)
+	assert.Equal(t, Position{1070, -1734, 348}, assist.AttackerPosition)
+	assert.Equal(t, Position{-795, -663, 517}, assist.VictimPosition)
+
+	assert.Equal(t, PlayerDominationEvent{
+		PlayerTrigger: PlayerTrigger{lyreix, kaidus},
+	}, ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" triggered "domination" against "kaidus<7><[U:1:45115290]><Red>"`))
+	assert.Equal(t, PlayerRevengeEvent{
+		LogProperties: LogProperties{Properties{{"assist", "1"}}},
+		PlayerTrigger: PlayerTrigger{kaidus, lyreix},
+		Assist:        true,
+	}, ParseEvent(`"kaidus<7><[U:1:45115290]><Red>" triggered "revenge" against "Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" (assist "1")`))
+
+	assert.Equal(t, PlayerSuicideEvent{
+		LogProperties: LogProperties{Properties{{"attacker_position", "-718 1641 215"}}},
+		Player:        lyreix,
+		Weapon:        "world",
+		Position:      Position{-718, 1641, 215},
+	}, ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" committed suicide with "world" (attacker_position "-718 1641 215")`))
+
+	// K/A/D over a whole match
+	kills, assists, deaths := 0, 0, 0
+	for _, message := range readCorpus(t) {
+		switch e := ParseEvent(message).(type) {
+		case PlayerKilledEvent:
+			if e.Player1.SteamId == lyreix.SteamId {
+				kills++
+			}
+			if e.Player2.SteamId == lyreix.SteamId {
+				deaths++
+			}
+		case PlayerKillAssistEvent:
+			if e.Player1.SteamId == lyreix.SteamId {
+				assists++
+			}
+		case PlayerSuicideEvent:
+			if e.Player.SteamId == lyreix.SteamId {
+				deaths++
+			}
+		}
+	}
+	assert.Equal(t, 12, kills)
+	assert.Equal(t, 5, assists)
+	assert.Equal(t, 14, deaths)
+}
+
+func TestParsePosition(t *testing.T) {
+	p, ok := ParsePosition("-2310 -303 256")
+	assert.True(t, ok)
+	assert.Equal(t, Position{-2310, -303, 256}, p)
+	assert.Equal(t, "-2310 -303 256", p.String())
+
+	for _, s := range []string{"", "1 2", "1 2 3 4", "1 2 z", "1.5 2 3"} {
+		_, ok := ParsePosition(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func TestParseObjectEvents(t *testing.T) {
+	engie := PlayerData{Username: "b4nny", UserId: "10", SteamId: mustParseSteamID("[U:1:10403006]"), Team: "Red"}
+	spy := PlayerData{Username: "mu", UserId: "12", SteamId: mustParseSteamID("[U:1:33573908]"), Team: "Blue"}
+
+	e := ParseEvent(`"b4nny<10><[U:1:10403006]><Red>" triggered "player_builtobject" (object "OBJ_SENTRYGUN") (position "-1520 400 128")`)
+	assert.Equal(t, PlayerBuiltObjectEvent{
+		LogProperties: LogProperties{Properties{
+			{"object", "OBJ_SENTRYGUN"},
+			{"position", "-1520 400 128"},
+		}},
+		Player:   engie,
+		Object:   ObjectSentryGun,
+		Position: Position{-1520, 400, 128},
+	}, e)
+
+	e = ParseEvent(`"mu<12><[U:1:33573908]><Blue>" triggered "killedobject" (object "OBJ_DISPENSER") (weapon "tf_projectile_pipe") (objectowner "b4nny<10><[U:1:10403006]><Red>") (attacker_position "-1301 388 131")`)
+	require.IsType(t, PlayerKilledObjectEvent{}, e)
+	killed := e.(PlayerKilledObjectEvent)
+	assert.Equal(t, spy, killed.Player)
+	assert.Equal(t, engie, killed.Owner)
+	assert.Equal(t, ObjectDispenser, killed.Object)
+	assert.Equal(t, "tf_projectile_pipe", killed.Weapon)
+	assert.False(t, killed.Assist)
+	assert.Equal(t, Position{-1301, 388, 131}, killed.AttackerPosition)
+
+	e = ParseEvent(`"b4nny<10><[U:1:10403006]><Red>" triggered "player_carryobject" (object "OBJ_TELEPORTER") (position "1 2 3")`)
+	assert.IsType(t, PlayerCarriedObjectEvent{}, e)
+	e = ParseEvent(`"b4nny<10><[U:1:10403006]><Red>" triggered "player_dropobject" (object "OBJ_TELEPORTER") (position "1 2 3")`)
+	assert.IsType(t, PlayerDroppedObjectEvent{}, e)
+	e = ParseEvent(`"b4nny<10><[U:1:10403006]><Red>" triggered "object_detonated" (object "OBJ_SENTRYGUN") (position "1 2 3")`)
+	require.IsType(t, ObjectDetonatedEvent{}, e)
+	assert.Equal(t, ObjectSentryGun, e.(ObjectDetonatedEvent).Object)
+
+	// unknown objects and owners are left unrecognised
+	assert.IsType(t, UnknownEvent{}, ParseEvent(`"b4nny<10><[U:1:10403006]><Red>" triggered "player_builtobject" (object "OBJ_CATAPULT")`))
+	assert.IsType(t, UnknownEvent{}, ParseEvent(`"mu<12><[U:1:33573908]><Blue>" triggered "killedobject" (object "OBJ_DISPENSER") (objectowner "nobody")`))
+
+	for _, o := range []Object{ObjectDispenser, ObjectTeleporter, ObjectSentryGun, ObjectSapper} {
+		parsed, ok := ParseObject(o.String())
+		assert.True(t, ok)
+		assert.Equal(t, o, parsed)
+	}
+
+	var built []Object
+	handler := &EventListener{PlayerBuiltObject: func(e PlayerBuiltObjectEvent) {
+		built = append(built, e.Object)
+	}}
+	m := ParseLine(`"b4nny<10><[U:1:10403006]><Red>" triggered "player_builtobject" (object "OBJ_DISPENSER") (position "1 2 3")`)
+	m.CallHandler(handler)
+	assert.Equal(t, []Object{ObjectDispenser}, built)
+}
+
+func TestParseUberEvents(t *testing.T) {
+	medic := PlayerData{Username: "Lyreix | TF2Stadium.com", UserId: "4", SteamId: mustParseSteamID("[U:1:56108026]"), Team: "Blue"}
+
+	assert.Equal(t, PlayerChargeReadyEvent{Player: medic},
+		ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" triggered "chargeready"`))
+
+	e := ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" triggered "chargedeployed" (medigun "kritzkrieg")`)
+	require.IsType(t, PlayerChargeDeployedEvent{}, e)
+	assert.Equal(t, medic, e.(PlayerChargeDeployedEvent).Player)
+	assert.Equal(t, "kritzkrieg", e.(PlayerChargeDeployedEvent).Medigun)
+
+	e = ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" triggered "chargeended" (duration "7.5")`)
+	require.IsType(t, PlayerChargeEndedEvent{}, e)
+	assert.Equal(t, 7500*time.Millisecond, e.(PlayerChargeEndedEvent).Duration)
+
+	e = ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" triggered "first_heal_after_spawn" (time "2.1")`)
+	require.IsType(t, PlayerFirstHealEvent{}, e)
+	assert.Equal(t, 2100*time.Millisecond, e.(PlayerFirstHealEvent).Time)
+
+	e = ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" triggered "lost_uber_advantage" (time "44")`)
+	require.IsType(t, PlayerLostUberAdvantageEvent{}, e)
+	assert.Equal(t, 44*time.Second, e.(PlayerLostUberAdvantageEvent).Time)
+
+	// a malformed duration leaves the line unrecognised
+	assert.IsType(t, UnknownEvent{},
+		ParseEvent(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" triggered "chargeended" (duration "long")`))
+
+	var deployed []string
+	handler := &EventListener{PlayerChargeDeployed: func(e PlayerChargeDeployedEvent) {
+		deployed = append(deployed, e.Medigun)
+	}}
+	m := ParseLine(`"Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" triggered "chargedeployed" (medigun "medigun")`)
+	m.CallHandler(handler)
+	assert.Equal(t, []string{"medigun"}, deployed)
+}
+
+func TestParseLifecycleEvents(t *testing.T) {
+	assert.Equal(t, LogFileStartedEvent{
+		LogProperties: LogProperties{Properties{
+			{"file", "logs/L0309000.log"},
+			{"game", "/home/tf2/tf"},
+			{"version", "3358291"},
+		}},
+		File:    "logs/L0309000.log",
+		Game:    "/home/tf2/tf",
+		Version: "3358291",
+	}, ParseEvent(`Log file started (file "logs/L0309000.log") (game "/home/tf2/tf") (version "3358291")`))
+	assert.Equal(t, MapLoadingEvent{Map: "cp_badlands"}, ParseEvent(`Loading map "cp_badlands"`))
+	assert.Equal(t, MapStartedEvent{
+		LogProperties: LogProperties{Properties{{"CRC", "2e1d2f6f3c8a4b5e"}}},
+		Map:           "cp_badlands",
+		CRC:           "2e1d2f6f3c8a4b5e",
+	}, ParseEvent(`Started map "cp_badlands" (CRC "2e1d2f6f3c8a4b5e")`))
+
+	for message, expected := range map[string]Event{
+		`World triggered "Round_Start"`:       WorldRoundStartEvent{},
+		`World triggered "Round_Setup_Begin"`: WorldRoundSetupBeginEvent{},
+		`World triggered "Round_Setup_End"`:   WorldRoundSetupEndEvent{},
+		`World triggered "Round_Overtime"`:    WorldRoundOvertimeEvent{},
+		`World triggered "Round_Stalemate"`:   WorldRoundStalemateEvent{},
+		`World triggered "Game_Paused"`:       WorldGamePausedEvent{},
+		`World triggered "Game_Unpaused"`:     WorldGameUnpausedEvent{},
+	} {
+		assert.Equal(t, expected, ParseEvent(message), message)
+	}
+
+	e := ParseEvent(`World triggered "Round_Length" (seconds "225.74")`)
+	require.IsType(t, WorldRoundLengthEvent{}, e)
+	assert.Equal(t, 225740*time.Millisecond, e.(WorldRoundLengthEvent).Length)
+
+	assert.Equal(t, WorldIntermissionWinLimitEvent{Team: "RED"},
+		ParseEvent(`Team "RED" triggered "Intermission_Win_Limit"`))
+	assert.Equal(t, WorldIntermissionWinLimitEvent{},
+		ParseEvent(`World triggered "Intermission_Win_Limit"`))
+	assert.Equal(t, TeamFinalScoreEvent{Team: "Blue", Score: 3, Players: 6},
+		ParseEvent(`Team "Blue" final score "3" with "6" players`))
+	assert.Equal(t, TeamScoreUpdateEvent{Team: "Blue", Score: 3, Players: 6},
+		ParseEvent(`Team "Blue" current score "3" with "6" players`))
+
+	m := ParseLine(`World triggered "Round_Start"`)
+	assert.Equal(t, WorldRoundStart, m.Type)
+
+	// a whole match, in order
+	var progress []string
+	handler := &EventListener{
+		LogFileStarted:  func(LogFileStartedEvent) { progress = append(progress, "log started") },
+		MapStarted:      func(e MapStartedEvent) { progress = append(progress, e.Map) },
+		WorldRoundStart: func(WorldRoundStartEvent) { progress = append(progress, "round") },
+		WorldRoundWin:   func(winner string) { progress = append(progress, winner) },
+		TeamFinalScore: func(e TeamFinalScoreEvent) {
+			progress = append(progress, e.Team+" "+strconv.Itoa(e.Score))
+		},
+		GameOver:      func() { progress = append(progress, "game over") },
+		LogFileClosed: func() { progress = append(progress, "log closed") },
+	}
+	for _, message := range readCorpus(t) {
+		m := ParseLine(message)
+		m.CallHandler(handler)
+	}
+	assert.Equal(t, []string{
+		"log started", "cp_badlands",
+		"round", "Blue", "round", "Blue", "round", "Red", "round", "Blue", "round",
+		"game over", "Red 1", "Blue 3", "log closed",
+	}, progress)
+}
+
+func TestParseEventProperties(t *testing.T) {
+	e := ParseEvent(logs[13])
+	assert.Equal(t, Properties{
+		{"damage", "100"},
+		{"realdamage", "88"},
+		{"weapon", "iron_bomber"},
+	}, e.Properties())
+
+	realdamage, ok := e.Properties().Int("realdamage")
+	assert.True(t, ok)
+	assert.Equal(t, 88, realdamage)
+
+	// properties added by plugins are kept too
+	e = ParseEvent(`"Tedstur<9><[U:1:98355052]><Red>" killed "Lyreix | TF2Stadium.com<4><[U:1:56108026]><Blue>" with "scattergun" (crit "crit") (attacker_position "-2310 -303 256") (victim_position "-2180 -474 256") (headshot "1")`)
+	require.IsType(t, PlayerKilledEvent{}, e)
+	assert.Equal(t, Properties{
+		{"crit", "crit"},
+		{"attacker_position", "-2310 -303 256"},
+		{"victim_position", "-2180 -474 256"},
+		{"headshot", "1"},
+	}, e.Properties())
+
+	_, ok = e.Properties().Get("customkill")
+	assert.False(t, ok)
+	assert.Empty(t, ParseEvent(logs[3]).Properties())
+}
+
+func TestHandleEvent(t *testing.T) {
+	var said, cvar string
+	var kills int
+
+	handler := &EventListener{
+		PlayerGlobalMessage: func(p PlayerData, text string) {
+			assert.Equal(t, text, p.Text)
+			said = text
+		},
+		PlayerKilled: func(PlayerKill) {
+			kills++
+		},
+		// the value comes first, as with the reflective CallHandler
+		CVarChange: func(value, variable string) {
+			cvar = variable + "=" + value
+		},
+	}
+
+	for _, line := range logs {
+		m := ParseLine(line)
+		m.CallHandler(handler)
+	}
+
+	assert.Equal(t, "hello gringos", said)
+	assert.Equal(t, 2, kills)
+	assert.Equal(t, "sv_password=***PROTECTED***", cvar)
+}
+
+func TestParseLogEntryE(t *testing.T) {
+	line := `L 03/09/2016 - 02:50:52: "Sk1LL0<2><[U:1:198288660]><Red>" say "hello gringos"`
+
+	m, err := ParseLogEntryE(line, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2016, 3, 9, 2, 50, 52, 0, time.UTC), m.Timestamp)
+	assert.Equal(t, line[25:], m.Message)
+	assert.Equal(t, PlayerGlobalMessage, m.Parsed.Type)
+	assert.Equal(t, m, ParseLogEntry(line))
+
+	berlin := time.FixedZone("CET", 60*60)
+	m, err = ParseLogEntryE(line, berlin)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2016, 3, 9, 1, 50, 52, 0, time.UTC), m.Timestamp.UTC())
+
+	for _, line := range []string{
+		"",
+		"L",
+		"L 03/09/2016 - 02:50:52:",
+		"X 03/09/2016 - 02:50:52: message",
+		"L 03/09/2016 - 02:50:52 message",
+		"L 3/9/2016 - 02:50:52: message",
+		"L 03/09/2016 -  2:50:52: message",
+		"L 0x/09/2016 - 02:50:52: message",
+	} {
+		m, err := ParseLogEntryE(line, nil)
+		require.Error(t, err, line)
+		require.IsType(t, &LogEntryError{}, err)
+		assert.Equal(t, line, err.(*LogEntryError).Line)
+		assert.Equal(t, -1, m.Parsed.Type, line)
+		assert.Nil(t, m.Parsed.Event, line)
+		assert.Equal(t, m, ParseLogEntry(line))
+	}
+
+	// a bad date still gives the message
+	m, err = ParseLogEntryE(`L 13/45/2016 - 02:50:52: Log file closed.`, nil)
+	require.Error(t, err)
+	assert.NotNil(t, err.(*LogEntryError).Err)
+	assert.True(t, m.Timestamp.IsZero())
+	assert.Equal(t, LogFileClosedEvent{}, m.Parsed.Event)
+}
+
+func FuzzParseLogEntry(f *testing.F) {
+	f.Add(`L 03/09/2016 - 02:50:52: "Sk1LL0<2><[U:1:198288660]><Red>" say "hello gringos"`)
+	f.Add(`L 02/30/2016 - 25:61:61: World triggered "Round_Start"`)
+	f.Add(`L 03/09/2016 - 02:50:52: `)
+	f.Add(`L 03/09/2016`)
+	for _, message := range logs {
+		f.Add("L 03/09/2016 - 02:31:20: " + message)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		m, err := ParseLogEntryE(line, nil)
+		if err != nil {
+			if _, ok := err.(*LogEntryError); !ok {
+				t.Fatalf("error of type %T", err)
+			}
+			if err.(*LogEntryError).Err == nil && m.Parsed.Event != nil {
+				t.Fatal("event returned for a line without a log prefix")
+			}
+			return
+		}
+
+		if m.Message != line[25:] {
+			t.Fatalf("message %q from %q", m.Message, line)
+		}
+		if ts := m.Timestamp.Format("01/02/2006 - 15:04:05"); ts != line[2:23] {
+			t.Fatalf("timestamp %q from %q", ts, line)
+		}
+		if m.Parsed.Event == nil {
+			t.Fatal("no event")
+		}
+	})
+}
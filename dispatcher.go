@@ -0,0 +1,225 @@
+package TF2RconWrapper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/TF2Stadium/rcon"
+)
+
+// dispatchReadTimeout is how long the dispatcher waits on an idle socket
+// before reading again. Timeouts while idle are not errors.
+const dispatchReadTimeout = 1 * time.Minute
+
+var errDispatcherClosed = errors.New("RCON connection closed")
+
+// dispatcher owns a single rcon.RemoteConsole. One goroutine reads every
+// response packet from the socket and routes it, by request ID, to the
+// query waiting for it, so any number of goroutines can have queries in
+// flight on the same connection at once.
+type dispatcher struct {
+	rc   *rcon.RemoteConsole
+	lost func(*dispatcher, error) // called in a new goroutine if the socket fails unexpectedly
+
+	// mu serialises writes, as request IDs are allocated inside
+	// rc.Write, and guards pending and err
+	mu      sync.Mutex
+	pending map[int]*pendingQuery
+	err     error
+	closing bool
+
+	done chan struct{} // closed once the read loop exits
+}
+
+// pendingQuery is a query waiting for its response. Every packet carrying
+// reqID is appended to resp; the response to the empty sentinel command
+// written after it marks the end of the output (see send).
+type pendingQuery struct {
+	reqID      int
+	sentinelID int
+
+	resp bytes.Buffer
+	done chan error
+}
+
+func newDispatcher(rc *rcon.RemoteConsole, lost func(*dispatcher, error)) *dispatcher {
+	d := &dispatcher{
+		rc:      rc,
+		lost:    lost,
+		pending: make(map[int]*pendingQuery),
+		done:    make(chan struct{}),
+	}
+
+	go d.run()
+	return d
+}
+
+// send writes req followed by an empty sentinel command. The server
+// executes commands in order, so every response packet carrying req's ID
+// arrives before the sentinel's response; their bodies are concatenated to
+// reassemble output that the server split across several packets.
+func (d *dispatcher) send(ctx context.Context, req string) (*pendingQuery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	written := d.closeOnDone(ctx)
+	reqID, err := d.rc.Write(req)
+	var sentinelID int
+	if err == nil {
+		sentinelID, err = d.rc.Write("")
+	}
+	written()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	p := &pendingQuery{
+		reqID:      reqID,
+		sentinelID: sentinelID,
+		done:       make(chan error, 1),
+	}
+	d.pending[reqID] = p
+	d.pending[sentinelID] = p
+
+	return p, nil
+}
+
+// write sends req without waiting for a response
+func (d *dispatcher) write(ctx context.Context, req string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+
+	written := d.closeOnDone(ctx)
+	_, err := d.rc.Write(req)
+	written()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// closeOnDone closes the socket if ctx is done before the returned function
+// is called. rcon has no write deadlines, and a write only blocks once the
+// server has stopped reading, so this is what unblocks it, failing the
+// connection, instead of holding d.mu and every other query up forever.
+func (d *dispatcher) closeOnDone(ctx context.Context) (written func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	var mu sync.Mutex
+	finished := false
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			if !finished {
+				d.rc.Close()
+			}
+			mu.Unlock()
+		case <-done:
+		}
+	}()
+	return func() {
+		mu.Lock()
+		finished = true
+		mu.Unlock()
+		close(done)
+	}
+}
+
+// forget stops routing responses to p, whose caller gave up on it
+func (d *dispatcher) forget(p *pendingQuery) {
+	d.mu.Lock()
+	delete(d.pending, p.reqID)
+	delete(d.pending, p.sentinelID)
+	d.mu.Unlock()
+}
+
+// close closes the socket, which fails every pending query and stops the
+// read loop
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	d.closing = true
+	d.mu.Unlock()
+
+	d.rc.Close()
+	<-d.done
+}
+
+func (d *dispatcher) run() {
+	defer close(d.done)
+
+	for {
+		body, respID, err := d.rc.Read(dispatchReadTimeout)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+
+			if !d.fail(err) && d.lost != nil {
+				// lost takes the connection's lock, which close's caller
+				// may hold while waiting for this loop to return
+				go d.lost(d, err)
+			}
+			return
+		}
+
+		d.mu.Lock()
+		p, ok := d.pending[respID]
+		if ok {
+			if respID == p.sentinelID {
+				delete(d.pending, p.reqID)
+				delete(d.pending, p.sentinelID)
+				p.done <- nil
+			} else {
+				p.resp.WriteString(body)
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// fail marks the dispatcher as dead and fails every pending query with err.
+// It reports whether the socket was closed on purpose.
+func (d *dispatcher) fail(err error) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil || d.closing {
+		err = errDispatcherClosed
+	}
+	d.err = err
+
+	for id, p := range d.pending {
+		if id == p.reqID {
+			p.done <- err
+		}
+	}
+	d.pending = make(map[int]*pendingQuery)
+
+	return d.closing
+}
+
+// alive reports whether the read loop is still running
+func (d *dispatcher) alive() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err == nil
+}
@@ -0,0 +1,731 @@
+package TF2RconWrapper
+
+import (
+	"strconv"
+	"time"
+)
+
+// Event is a parsed log message. It is implemented only by the *Event types
+// in this package, so a type switch over them covers every event ParseEvent
+// can return.
+type Event interface {
+	// Properties returns the (key "value") pairs trailing the message
+	Properties() Properties
+
+	// legacy returns the event as a ParsedMsg Type and Data
+	legacy() (int, interface{})
+}
+
+// LogProperties is embedded in every event to hold the properties trailing
+// its message, including those the event has fields for
+type LogProperties struct {
+	Props Properties `json:"properties,omitempty"`
+}
+
+func (p LogProperties) Properties() Properties {
+	return p.Props
+}
+
+type PlayerConnectedEvent struct {
+	LogProperties
+	Player  PlayerData
+	Address string
+}
+
+type PlayerDisconnectedEvent struct {
+	LogProperties
+	Player PlayerData
+	Reason string
+}
+
+type PlayerGlobalMessageEvent struct {
+	LogProperties
+	Player PlayerData
+	Text   string
+}
+
+type PlayerTeamMessageEvent struct {
+	LogProperties
+	Player PlayerData
+	Text   string
+}
+
+type PlayerChangedClassEvent struct {
+	LogProperties
+	Player PlayerData
+	Class  string
+}
+
+type PlayerChangedTeamEvent struct {
+	LogProperties
+	Player  PlayerData
+	NewTeam string
+}
+
+type PlayerSpawnedEvent struct {
+	LogProperties
+	Player PlayerData
+	Class  string
+}
+
+type PlayerKilledEvent struct {
+	LogProperties
+	PlayerKill
+}
+
+// PlayerKillAssistEvent is sent after a kill for the player who assisted it.
+// Player1 is the assister and Player2 the victim.
+type PlayerKillAssistEvent struct {
+	LogProperties
+	PlayerTrigger
+	AssisterPosition Position
+	AttackerPosition Position
+	VictimPosition   Position
+}
+
+// PlayerDominationEvent is sent when Player1 starts dominating Player2.
+// Assist is set when Player1 got there by assisting.
+type PlayerDominationEvent struct {
+	LogProperties
+	PlayerTrigger
+	Assist bool
+}
+
+// PlayerRevengeEvent is sent when Player1 gets revenge on Player2, who was
+// dominating them
+type PlayerRevengeEvent struct {
+	LogProperties
+	PlayerTrigger
+	Assist bool
+}
+
+type PlayerSuicideEvent struct {
+	LogProperties
+	Player   PlayerData
+	Weapon   string
+	Position Position
+}
+
+type PlayerDamagedEvent struct {
+	LogProperties
+	PlayerDamage
+}
+
+type PlayerHealedEvent struct {
+	LogProperties
+	PlayerHeal
+}
+
+// PlayerKilledMedicEvent is sent when Player1 kills Player2, a medic
+type PlayerKilledMedicEvent struct {
+	LogProperties
+	PlayerTrigger
+	Healing    int  // healing done by the medic during this life
+	Ubercharge bool // whether the medic died with a full charge
+}
+
+type PlayerUberFinishedEvent struct {
+	LogProperties
+	Player PlayerData
+}
+
+// PlayerChargeReadyEvent is sent when a medic's ubercharge is full
+type PlayerChargeReadyEvent struct {
+	LogProperties
+	Player PlayerData
+}
+
+// PlayerChargeDeployedEvent is sent when a medic pops their charge
+type PlayerChargeDeployedEvent struct {
+	LogProperties
+	Player  PlayerData
+	Medigun string // "medigun", "kritzkrieg", "quickfix", "vaccinator"
+}
+
+// PlayerChargeEndedEvent is sent when a medic's charge runs out
+type PlayerChargeEndedEvent struct {
+	LogProperties
+	Player   PlayerData
+	Duration time.Duration
+}
+
+// PlayerFirstHealEvent is sent when a medic first heals someone after
+// spawning. Time is how long after spawning that was.
+type PlayerFirstHealEvent struct {
+	LogProperties
+	Player PlayerData
+	Time   time.Duration
+}
+
+// PlayerLostUberAdvantageEvent is sent when a medic's team built its charge
+// first but the other team used theirs first. Time is how long the advantage
+// lasted.
+type PlayerLostUberAdvantageEvent struct {
+	LogProperties
+	Player PlayerData
+	Time   time.Duration
+}
+
+// PlayerBuiltObjectEvent is sent when a player finishes placing a building
+type PlayerBuiltObjectEvent struct {
+	LogProperties
+	Player   PlayerData
+	Object   Object
+	Position Position
+}
+
+// PlayerKilledObjectEvent is sent when Player destroys a building belonging
+// to Owner. Assist is set for players who helped destroy it.
+type PlayerKilledObjectEvent struct {
+	LogProperties
+	Player           PlayerData
+	Owner            PlayerData
+	Object           Object
+	Weapon           string
+	Assist           bool
+	AttackerPosition Position
+}
+
+// PlayerCarriedObjectEvent is sent when an engineer picks up a building
+type PlayerCarriedObjectEvent struct {
+	LogProperties
+	Player   PlayerData
+	Object   Object
+	Position Position
+}
+
+// PlayerDroppedObjectEvent is sent when an engineer places a carried building
+type PlayerDroppedObjectEvent struct {
+	LogProperties
+	Player   PlayerData
+	Object   Object
+	Position Position
+}
+
+// ObjectDetonatedEvent is sent when an engineer destroys their own building
+type ObjectDetonatedEvent struct {
+	LogProperties
+	Player   PlayerData
+	Object   Object
+	Position Position
+}
+
+type PlayerBlockedCaptureEvent struct {
+	LogProperties
+	CPData
+	Player   PlayerData
+	Position Position
+}
+
+type PlayerPickedUpItemEvent struct {
+	LogProperties
+	ItemPickup
+}
+
+type TeamPointCaptureEvent struct {
+	LogProperties
+	CPData
+	Team string
+}
+
+type TeamScoreUpdateEvent struct {
+	LogProperties
+	Team    string
+	Score   int
+	Players int
+}
+
+// TeamFinalScoreEvent is sent for each team at the end of a game
+type TeamFinalScoreEvent struct {
+	LogProperties
+	Team    string
+	Score   int
+	Players int
+}
+
+type WorldGameOverEvent struct {
+	LogProperties
+	Reason string
+}
+
+type WorldRoundWinEvent struct {
+	LogProperties
+	Winner string
+}
+
+type WorldRoundStartEvent struct {
+	LogProperties
+}
+
+// WorldRoundSetupBeginEvent is sent when a round's setup time starts, on
+// maps that have one
+type WorldRoundSetupBeginEvent struct {
+	LogProperties
+}
+
+// WorldRoundSetupEndEvent is sent when a round's setup time is over and the
+// gates open
+type WorldRoundSetupEndEvent struct {
+	LogProperties
+}
+
+type WorldRoundOvertimeEvent struct {
+	LogProperties
+}
+
+// WorldRoundLengthEvent is sent after a round ends with how long it lasted
+type WorldRoundLengthEvent struct {
+	LogProperties
+	Length time.Duration
+}
+
+type WorldRoundStalemateEvent struct {
+	LogProperties
+}
+
+type WorldGamePausedEvent struct {
+	LogProperties
+}
+
+type WorldGameUnpausedEvent struct {
+	LogProperties
+}
+
+// WorldIntermissionWinLimitEvent is sent when a team reaches the win limit.
+// Team is empty if the server didn't log which team it was.
+type WorldIntermissionWinLimitEvent struct {
+	LogProperties
+	Team string
+}
+
+type ServerCvarEvent struct {
+	LogProperties
+	CvarData
+}
+
+type LogFileClosedEvent struct {
+	LogProperties
+}
+
+// LogFileStartedEvent is the first message of a log file
+type LogFileStartedEvent struct {
+	LogProperties
+	File    string
+	Game    string
+	Version string
+}
+
+// MapLoadingEvent is sent when the server starts changing to Map
+type MapLoadingEvent struct {
+	LogProperties
+	Map string
+}
+
+// MapStartedEvent is sent once Map has loaded
+type MapStartedEvent struct {
+	LogProperties
+	Map string
+	CRC string
+}
+
+// TournamentStartedEvent is sent when a tournament mode match starts. Map
+// is only known to a Source that saw the map load.
+type TournamentStartedEvent struct {
+	LogProperties
+	BlueTeam string
+	RedTeam  string
+	Map      string
+}
+
+func (e *TournamentStartedEvent) setTeamName(t TournamentTeamEvent) {
+	switch t.Team {
+	case "Blue":
+		e.BlueTeam = t.Name
+	case "Red":
+		e.RedTeam = t.Name
+	}
+}
+
+// TournamentTeamEvent is one of the "Blue Team: name" and "Red Team: name"
+// lines following "Tournament mode started". A Source folds them into the
+// TournamentStartedEvent they belong to.
+type TournamentTeamEvent struct {
+	LogProperties
+	Team string
+	Name string
+}
+
+type RconCommandEvent struct {
+	LogProperties
+	From    string // IP address
+	Command string
+}
+
+// UnknownEvent is a message without an event type of its own, like
+//
+//	"name<2><[U:1:198288660]><Red>" changed name to "new name"
+//
+// For messages starting with a player, Player and Verb are set. Args has the
+// remaining words and quoted values before the properties, apart from a
+// second player, which is set as Target.
+type UnknownEvent struct {
+	LogProperties
+	Message string
+
+	Player *PlayerData
+	Verb   string
+	Target *PlayerData
+	Args   []string
+}
+
+func (e PlayerConnectedEvent) legacy() (int, interface{}) {
+	return PlayerConnected, e.Player
+}
+
+func (e PlayerDisconnectedEvent) legacy() (int, interface{}) {
+	return PlayerDisconnected, e.Player
+}
+
+func (e PlayerGlobalMessageEvent) legacy() (int, interface{}) {
+	d := e.Player
+	d.Text = e.Text
+	return PlayerGlobalMessage, d
+}
+
+func (e PlayerTeamMessageEvent) legacy() (int, interface{}) {
+	d := e.Player
+	d.Text = e.Text
+	return PlayerTeamMessage, d
+}
+
+func (e PlayerChangedClassEvent) legacy() (int, interface{}) {
+	d := e.Player
+	d.Class = e.Class
+	return PlayerChangedClass, d
+}
+
+func (e PlayerChangedTeamEvent) legacy() (int, interface{}) {
+	d := e.Player
+	d.NewTeam = e.NewTeam
+	return PlayerChangedTeam, d
+}
+
+func (e PlayerSpawnedEvent) legacy() (int, interface{}) {
+	d := e.Player
+	d.Class = e.Class
+	return PlayerSpawned, d
+}
+
+func (e PlayerKilledEvent) legacy() (int, interface{}) {
+	return PlayerKilled, e.PlayerKill
+}
+
+func (e PlayerDamagedEvent) legacy() (int, interface{}) {
+	return PlayerDamaged, e.PlayerDamage
+}
+
+func (e PlayerHealedEvent) legacy() (int, interface{}) {
+	return PlayerHealed, e.PlayerHeal
+}
+
+func (e PlayerKilledMedicEvent) legacy() (int, interface{}) {
+	return PlayerKilledMedic, e.PlayerTrigger
+}
+
+func (e PlayerUberFinishedEvent) legacy() (int, interface{}) {
+	return PlayerUberFinished, e.Player
+}
+
+func (e PlayerBlockedCaptureEvent) legacy() (int, interface{}) {
+	return PlayerBlockedCapture, []interface{}{e.CPData, e.Player}
+}
+
+func (e PlayerPickedUpItemEvent) legacy() (int, interface{}) {
+	return PlayerPickedUpItem, e.ItemPickup
+}
+
+func (e TeamPointCaptureEvent) legacy() (int, interface{}) {
+	return TeamPointCapture, TeamData{CPData: e.CPData, Team: e.Team}
+}
+
+func (e TeamScoreUpdateEvent) legacy() (int, interface{}) {
+	return TeamScoreUpdate, TeamData{Team: e.Team, Score: strconv.Itoa(e.Score)}
+}
+
+func (e WorldGameOverEvent) legacy() (int, interface{}) {
+	return WorldGameOver, nil
+}
+
+func (e WorldRoundWinEvent) legacy() (int, interface{}) {
+	return WorldRoundWin, e.Winner
+}
+
+func (e WorldRoundStartEvent) legacy() (int, interface{}) {
+	return WorldRoundStart, nil
+}
+
+func (e TeamFinalScoreEvent) legacy() (int, interface{})            { return -1, nil }
+func (e WorldRoundSetupBeginEvent) legacy() (int, interface{})      { return -1, nil }
+func (e WorldRoundSetupEndEvent) legacy() (int, interface{})        { return -1, nil }
+func (e WorldRoundOvertimeEvent) legacy() (int, interface{})        { return -1, nil }
+func (e WorldRoundLengthEvent) legacy() (int, interface{})          { return -1, nil }
+func (e WorldRoundStalemateEvent) legacy() (int, interface{})       { return -1, nil }
+func (e WorldGamePausedEvent) legacy() (int, interface{})           { return -1, nil }
+func (e WorldGameUnpausedEvent) legacy() (int, interface{})         { return -1, nil }
+func (e WorldIntermissionWinLimitEvent) legacy() (int, interface{}) { return -1, nil }
+func (e LogFileStartedEvent) legacy() (int, interface{})            { return -1, nil }
+func (e MapLoadingEvent) legacy() (int, interface{})                { return -1, nil }
+func (e MapStartedEvent) legacy() (int, interface{})                { return -1, nil }
+
+func (e ServerCvarEvent) legacy() (int, interface{}) {
+	return ServerCvar, e.CvarData
+}
+
+func (e LogFileClosedEvent) legacy() (int, interface{}) {
+	return LogFileClosed, nil
+}
+
+func (e TournamentStartedEvent) legacy() (int, interface{}) {
+	return TournamentStarted, nil
+}
+
+func (e TournamentTeamEvent) legacy() (int, interface{}) {
+	return -1, nil
+}
+
+func (e RconCommandEvent) legacy() (int, interface{}) {
+	return RconCommand, []string{e.From, e.Command}
+}
+
+func (e PlayerKillAssistEvent) legacy() (int, interface{})        { return -1, nil }
+func (e PlayerDominationEvent) legacy() (int, interface{})        { return -1, nil }
+func (e PlayerRevengeEvent) legacy() (int, interface{})           { return -1, nil }
+func (e PlayerSuicideEvent) legacy() (int, interface{})           { return -1, nil }
+func (e PlayerBuiltObjectEvent) legacy() (int, interface{})       { return -1, nil }
+func (e PlayerKilledObjectEvent) legacy() (int, interface{})      { return -1, nil }
+func (e PlayerCarriedObjectEvent) legacy() (int, interface{})     { return -1, nil }
+func (e PlayerDroppedObjectEvent) legacy() (int, interface{})     { return -1, nil }
+func (e ObjectDetonatedEvent) legacy() (int, interface{})         { return -1, nil }
+func (e PlayerChargeReadyEvent) legacy() (int, interface{})       { return -1, nil }
+func (e PlayerChargeDeployedEvent) legacy() (int, interface{})    { return -1, nil }
+func (e PlayerChargeEndedEvent) legacy() (int, interface{})       { return -1, nil }
+func (e PlayerFirstHealEvent) legacy() (int, interface{})         { return -1, nil }
+func (e PlayerLostUberAdvantageEvent) legacy() (int, interface{}) { return -1, nil }
+func (e UnknownEvent) legacy() (int, interface{})                 { return -1, nil }
+
+// HandleEvent calls the function in handler matching e, if it's set
+func (handler *EventListener) HandleEvent(e Event) {
+	switch e := e.(type) {
+	case PlayerConnectedEvent:
+		if handler.PlayerConnected != nil {
+			handler.PlayerConnected(e.Player)
+		}
+	case PlayerDisconnectedEvent:
+		if handler.PlayerDisconnected != nil {
+			handler.PlayerDisconnected(e.Player)
+		}
+	case PlayerGlobalMessageEvent:
+		if handler.PlayerGlobalMessage != nil {
+			_, d := e.legacy()
+			handler.PlayerGlobalMessage(d.(PlayerData), e.Text)
+		}
+	case PlayerTeamMessageEvent:
+		if handler.PlayerTeamMessage != nil {
+			_, d := e.legacy()
+			handler.PlayerTeamMessage(d.(PlayerData), e.Text)
+		}
+	case PlayerSpawnedEvent:
+		if handler.PlayerSpawned != nil {
+			_, d := e.legacy()
+			handler.PlayerSpawned(d.(PlayerData), e.Class)
+		}
+	case PlayerChangedClassEvent:
+		if handler.PlayerClassChanged != nil {
+			_, d := e.legacy()
+			handler.PlayerClassChanged(d.(PlayerData), e.Class)
+		}
+	case PlayerChangedTeamEvent:
+		if handler.PlayerTeamChange != nil {
+			_, d := e.legacy()
+			handler.PlayerTeamChange(d.(PlayerData), e.NewTeam)
+		}
+	case PlayerKilledEvent:
+		if handler.PlayerKilled != nil {
+			handler.PlayerKilled(e.PlayerKill)
+		}
+	case PlayerDamagedEvent:
+		if handler.PlayerDamaged != nil {
+			handler.PlayerDamaged(e.PlayerDamage)
+		}
+	case PlayerHealedEvent:
+		if handler.PlayerHealed != nil {
+			handler.PlayerHealed(e.PlayerHeal)
+		}
+	case PlayerKilledMedicEvent:
+		if handler.PlayerKilledMedic != nil {
+			handler.PlayerKilledMedic(e.PlayerTrigger)
+		}
+	case PlayerUberFinishedEvent:
+		if handler.PlayerUberFinished != nil {
+			handler.PlayerUberFinished(e.Player)
+		}
+	case PlayerKillAssistEvent:
+		if handler.PlayerKillAssist != nil {
+			handler.PlayerKillAssist(e)
+		}
+	case PlayerDominationEvent:
+		if handler.PlayerDomination != nil {
+			handler.PlayerDomination(e)
+		}
+	case PlayerRevengeEvent:
+		if handler.PlayerRevenge != nil {
+			handler.PlayerRevenge(e)
+		}
+	case PlayerSuicideEvent:
+		if handler.PlayerSuicide != nil {
+			handler.PlayerSuicide(e)
+		}
+	case PlayerChargeReadyEvent:
+		if handler.PlayerChargeReady != nil {
+			handler.PlayerChargeReady(e)
+		}
+	case PlayerChargeDeployedEvent:
+		if handler.PlayerChargeDeployed != nil {
+			handler.PlayerChargeDeployed(e)
+		}
+	case PlayerChargeEndedEvent:
+		if handler.PlayerChargeEnded != nil {
+			handler.PlayerChargeEnded(e)
+		}
+	case PlayerFirstHealEvent:
+		if handler.PlayerFirstHeal != nil {
+			handler.PlayerFirstHeal(e)
+		}
+	case PlayerLostUberAdvantageEvent:
+		if handler.PlayerLostUberAdvantage != nil {
+			handler.PlayerLostUberAdvantage(e)
+		}
+	case PlayerBuiltObjectEvent:
+		if handler.PlayerBuiltObject != nil {
+			handler.PlayerBuiltObject(e)
+		}
+	case PlayerKilledObjectEvent:
+		if handler.PlayerKilledObject != nil {
+			handler.PlayerKilledObject(e)
+		}
+	case PlayerCarriedObjectEvent:
+		if handler.PlayerCarriedObject != nil {
+			handler.PlayerCarriedObject(e)
+		}
+	case PlayerDroppedObjectEvent:
+		if handler.PlayerDroppedObject != nil {
+			handler.PlayerDroppedObject(e)
+		}
+	case ObjectDetonatedEvent:
+		if handler.ObjectDetonated != nil {
+			handler.ObjectDetonated(e)
+		}
+	case PlayerBlockedCaptureEvent:
+		if handler.PlayerBlockedCapture != nil {
+			handler.PlayerBlockedCapture(e.CPData, e.Player)
+		}
+	case PlayerPickedUpItemEvent:
+		if handler.PlayerItemPickup != nil {
+			handler.PlayerItemPickup(e.ItemPickup)
+		}
+	case TeamPointCaptureEvent:
+		if handler.TeamPointCapture != nil {
+			_, d := e.legacy()
+			handler.TeamPointCapture(d.(TeamData))
+		}
+	case TeamScoreUpdateEvent:
+		if handler.TeamScoreUpdate != nil {
+			_, d := e.legacy()
+			handler.TeamScoreUpdate(d.(TeamData))
+		}
+	case TeamFinalScoreEvent:
+		if handler.TeamFinalScore != nil {
+			handler.TeamFinalScore(e)
+		}
+	case WorldGameOverEvent:
+		if handler.GameOver != nil {
+			handler.GameOver()
+		}
+	case WorldRoundWinEvent:
+		if handler.WorldRoundWin != nil {
+			handler.WorldRoundWin(e.Winner)
+		}
+	case WorldRoundStartEvent:
+		if handler.WorldRoundStart != nil {
+			handler.WorldRoundStart(e)
+		}
+	case WorldRoundSetupBeginEvent:
+		if handler.WorldRoundSetupBegin != nil {
+			handler.WorldRoundSetupBegin(e)
+		}
+	case WorldRoundSetupEndEvent:
+		if handler.WorldRoundSetupEnd != nil {
+			handler.WorldRoundSetupEnd(e)
+		}
+	case WorldRoundOvertimeEvent:
+		if handler.WorldRoundOvertime != nil {
+			handler.WorldRoundOvertime(e)
+		}
+	case WorldRoundLengthEvent:
+		if handler.WorldRoundLength != nil {
+			handler.WorldRoundLength(e)
+		}
+	case WorldRoundStalemateEvent:
+		if handler.WorldRoundStalemate != nil {
+			handler.WorldRoundStalemate(e)
+		}
+	case WorldGamePausedEvent:
+		if handler.GamePaused != nil {
+			handler.GamePaused(e)
+		}
+	case WorldGameUnpausedEvent:
+		if handler.GameUnpaused != nil {
+			handler.GameUnpaused(e)
+		}
+	case WorldIntermissionWinLimitEvent:
+		if handler.IntermissionWinLimit != nil {
+			handler.IntermissionWinLimit(e)
+		}
+	case ServerCvarEvent:
+		if handler.CVarChange != nil {
+			// the value comes first, as it always has
+			handler.CVarChange(e.Value, e.Variable)
+		}
+	case LogFileClosedEvent:
+		if handler.LogFileClosed != nil {
+			handler.LogFileClosed()
+		}
+	case LogFileStartedEvent:
+		if handler.LogFileStarted != nil {
+			handler.LogFileStarted(e)
+		}
+	case MapLoadingEvent:
+		if handler.MapLoading != nil {
+			handler.MapLoading(e)
+		}
+	case MapStartedEvent:
+		if handler.MapStarted != nil {
+			handler.MapStarted(e)
+		}
+	case TournamentStartedEvent:
+		if handler.TournamentStarted != nil {
+			handler.TournamentStarted()
+		}
+		if handler.TournamentStart != nil {
+			handler.TournamentStart(e)
+		}
+	case RconCommandEvent:
+		if handler.RconCommand != nil {
+			handler.RconCommand(e.From, e.Command)
+		}
+	case UnknownEvent:
+		if handler.Unhandled != nil {
+			handler.Unhandled(e)
+		}
+	}
+}
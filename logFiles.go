@@ -0,0 +1,205 @@
+package TF2RconWrapper
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logFileTimeFormat is the start time in the names of log files
+const logFileTimeFormat = "20060102-150405"
+
+// LogFileConfig makes a Source write its server's raw log to Dir, in one file
+// per log file the server writes. Files are split where the server starts
+// and closes its own, and named secret_map_starttime.log once finished, or
+// ip-port_map_starttime.log for servers sending logs without a secret. A
+// file is in progress while it ends in .part.
+type LogFileConfig struct {
+	Dir string
+	// Gzip compresses finished files, adding .gz to their names
+	Gzip bool
+	// Finished, if set, is called with the path of every finished file. It
+	// runs on the source's delivery goroutine, where its handler runs, or on
+	// the goroutine calling SetLogFiles or removing the source if that's
+	// what finished the file. The source's lines wait for it to return.
+	Finished func(path string)
+}
+
+// logFiles writes the log files of a Source
+type logFiles struct {
+	mu     sync.Mutex
+	config LogFileConfig
+	f      *os.File // the file being written, if any
+	start  time.Time
+}
+
+// SetLogFiles starts writing s's log to files as config says, or stops if
+// config.Dir is empty. The file being written, if any, is finished first.
+func (s *Source) SetLogFiles(config LogFileConfig) error {
+	if config.Dir != "" {
+		if err := checkDir(config.Dir); err != nil {
+			return err
+		}
+	}
+
+	s.files.mu.Lock()
+	finished := s.files.finish(s.logName(), s.currentMap())
+	s.files.config = config
+	s.files.mu.Unlock()
+
+	finished()
+	return nil
+}
+
+// checkDir returns an error unless dir is an existing directory
+func checkDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// writeLogFile writes a log line to the current log file, starting a new one
+// if the line starts a new server log file
+func (s *Source) writeLogFile(line []byte, m LogMessage) {
+	lf := s.files
+	lf.mu.Lock()
+	finished := []func(){}
+	defer func() {
+		lf.mu.Unlock()
+		for _, f := range finished {
+			f()
+		}
+	}()
+
+	if lf.config.Dir == "" {
+		return
+	}
+
+	_, started := m.Parsed.Event.(LogFileStartedEvent)
+	if started {
+		finished = append(finished, lf.finish(s.logName(), s.currentMap()))
+	}
+
+	if lf.f == nil {
+		lf.start = m.Timestamp
+		if lf.start.IsZero() {
+			lf.start = time.Now()
+		}
+
+		name := s.logName() + "_" + lf.start.Format(logFileTimeFormat) + ".log.part"
+		f, err := os.OpenFile(filepath.Join(lf.config.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		lf.f = f
+	}
+
+	if _, err := lf.f.Write(line); err != nil {
+		log.Println(err)
+	}
+
+	if _, closed := m.Parsed.Event.(LogFileClosedEvent); closed {
+		// the map is only known once the log has started
+		finished = append(finished, lf.finish(s.logName(), s.currentMap()))
+	}
+}
+
+// closeLogFile finishes the file being written when s is removed
+func (s *Source) closeLogFile() {
+	s.files.mu.Lock()
+	finished := s.files.finish(s.logName(), s.currentMap())
+	s.files.mu.Unlock()
+
+	finished()
+}
+
+// logName is the name s's log files start with, its secret or, for servers
+// sending logs without one, its address
+func (s *Source) logName() string {
+	if s.Addr != "" {
+		return strings.NewReplacer("[", "", "]", "", ":", "-").Replace(s.Addr)
+	}
+	return s.Secret
+}
+
+func (s *Source) currentMap() string {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.mapName
+}
+
+// finish closes the file being written and gives it its final name. lf.mu
+// must be held. The returned function calls the Finished callback, once lf.mu
+// has been released, so that the callback can call SetLogFiles.
+func (lf *logFiles) finish(secret, mapName string) (finished func()) {
+	finished = func() {}
+	if lf.f == nil {
+		return
+	}
+
+	part := lf.f.Name()
+	lf.f.Close()
+	lf.f = nil
+
+	if mapName == "" {
+		mapName = "unknown"
+	}
+	// workshop maps look like workshop/cp_process_final.ugc12345
+	mapName = strings.NewReplacer("/", "_", `\`, "_").Replace(mapName)
+
+	path := filepath.Join(filepath.Dir(part), secret+"_"+mapName+"_"+lf.start.Format(logFileTimeFormat)+".log")
+	if lf.config.Gzip {
+		path += ".gz"
+		if err := gzipFile(part, path); err != nil {
+			log.Println(err)
+			return
+		}
+		os.Remove(part)
+	} else if err := os.Rename(part, path); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if cb := lf.config.Finished; cb != nil {
+		finished = func() { cb(path) }
+	}
+	return
+}
+
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package TF2RconWrapper
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readGzip(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestSourceLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+	s := newSource("1234", nil, false, QueueConfig{})
+
+	var finished []string
+	require.NoError(t, s.SetLogFiles(LogFileConfig{
+		Dir:      dir,
+		Gzip:     true,
+		Finished: func(path string) { finished = append(finished, path) },
+	}))
+
+	f, err := os.Open("testdata/match.log")
+	require.NoError(t, err)
+	defer f.Close()
+
+	var match []byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := append(scanner.Bytes(), '\n')
+		match = append(match, line...)
+		l.process(s, line)
+	}
+	require.NoError(t, scanner.Err())
+
+	matchPath := filepath.Join(dir, "1234_cp_badlands_20160309-023000.log.gz")
+	require.Equal(t, []string{matchPath}, finished)
+	assert.Equal(t, string(match), readGzip(t, matchPath))
+
+	// the next log is finished when the source is removed
+	next := []string{
+		`L 03/09/2016 - 03:01:00: Log file started (file "logs/L0309001.log") (game "/home/tf2/tf") (version "3358291")` + "\n",
+		`L 03/09/2016 - 03:01:01: Loading map "workshop/koth_product_rc8.ugc1234"` + "\n",
+		string(chatLine("gg")),
+	}
+	for _, line := range next {
+		l.process(s, []byte(line))
+	}
+	parts, _ := filepath.Glob(filepath.Join(dir, "*.part"))
+	assert.Len(t, parts, 1)
+
+	s.closeLogFile()
+	nextPath := filepath.Join(dir, "1234_workshop_koth_product_rc8.ugc1234_20160309-030100.log.gz")
+	require.Equal(t, []string{matchPath, nextPath}, finished)
+	assert.Equal(t, next[0]+next[1]+next[2], readGzip(t, nextPath))
+
+	parts, _ = filepath.Glob(filepath.Join(dir, "*.part"))
+	assert.Empty(t, parts)
+}
+
+func TestSourceLogFilesMidLog(t *testing.T) {
+	dir := t.TempDir()
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+	s := newSource("1234", nil, false, QueueConfig{})
+
+	// lines from before log files were set up aren't written, the rest of
+	// the server's log file is, though its start was missed
+	l.process(s, chatLine("hi"))
+	require.NoError(t, s.SetLogFiles(LogFileConfig{Dir: dir}))
+	l.process(s, chatLine("hello"))
+	l.process(s, []byte("L 03/09/2016 - 02:50:53: Log file closed.\n"))
+
+	b, err := os.ReadFile(filepath.Join(dir, "1234_unknown_20160309-025052.log"))
+	require.NoError(t, err)
+	assert.Equal(t, string(chatLine("hello"))+"L 03/09/2016 - 02:50:53: Log file closed.\n", string(b))
+
+	assert.Error(t, s.SetLogFiles(LogFileConfig{Dir: filepath.Join(dir, "missing")}))
+}
+
+func TestSourceLogFilesFinishedReconfigures(t *testing.T) {
+	dir := t.TempDir()
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+	s := newSource("1234", nil, false, QueueConfig{})
+
+	var finished []string
+	require.NoError(t, s.SetLogFiles(LogFileConfig{
+		Dir: dir,
+		Finished: func(path string) {
+			finished = append(finished, path)
+			// one file is enough
+			assert.NoError(t, s.SetLogFiles(LogFileConfig{}))
+		},
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.process(s, chatLine("hello"))
+		l.process(s, []byte("L 03/09/2016 - 02:50:53: Log file closed.\n"))
+		l.process(s, chatLine("hi"))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SetLogFiles from the Finished callback deadlocked")
+	}
+
+	require.Equal(t, []string{filepath.Join(dir, "1234_unknown_20160309-025052.log")}, finished)
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Len(t, files, 1)
+}
+
+func TestListenerLogDirs(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+
+	assert.Error(t, l.SetLogFiles(LogFileConfig{Dir: filepath.Join(dir, "missing")}))
+	assert.Error(t, l.SetLogFiles(LogFileConfig{Dir: file}))
+	assert.Error(t, l.SetLogRetention(LogRetention{SpoolDir: file}))
+	assert.Empty(t, l.logFiles.Dir)
+	assert.Empty(t, l.logRetention.SpoolDir)
+
+	require.NoError(t, l.SetLogFiles(LogFileConfig{Dir: dir}))
+	require.NoError(t, l.SetLogRetention(LogRetention{SpoolDir: dir}))
+	require.NoError(t, l.SetLogFiles(LogFileConfig{}))
+	assert.Equal(t, dir, l.logRetention.SpoolDir)
+}
@@ -1,74 +1,127 @@
 package TF2RconWrapper
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"log"
-	"math/rand"
 	"net"
-	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
 type EventListener struct {
-	PlayerConnected      func(PlayerData)
-	PlayerDisconnected   func(PlayerData)
-	PlayerGlobalMessage  func(PlayerData, string) // strings are chat message
-	PlayerTeamMessage    func(PlayerData, string)
-	PlayerSpawned        func(PlayerData, string) // string is class
-	PlayerClassChanged   func(PlayerData, string) // string is new classes
-	PlayerTeamChange     func(PlayerData, string) // string is new team
-	PlayerKilled         func(PlayerKill)
-	PlayerDamaged        func(PlayerDamage)
-	PlayerHealed         func(PlayerHeal)
-	PlayerKilledMedic    func(PlayerTrigger)
-	PlayerUberFinished   func(PlayerData)
-	PlayerBlockedCapture func(CPData, PlayerData) // cp blocked by player
-	PlayerItemPickup     func(ItemPickup)
-	TeamPointCapture     func(TeamData)
-	TeamScoreUpdate      func(TeamData)
-	GameOver             func()
-	WorldRoundWin        func(string) // string is team which won
-	CVarChange           func(variable string, value string)
-	LogFileClosed        func()
-	TournamentStarted    func()
-	RconCommand          func(from, command string) // from - IP Address, command - command executed
+	PlayerConnected         func(PlayerData)
+	PlayerDisconnected      func(PlayerData)
+	PlayerGlobalMessage     func(PlayerData, string) // strings are chat message
+	PlayerTeamMessage       func(PlayerData, string)
+	PlayerSpawned           func(PlayerData, string) // string is class
+	PlayerClassChanged      func(PlayerData, string) // string is new classes
+	PlayerTeamChange        func(PlayerData, string) // string is new team
+	PlayerKilled            func(PlayerKill)
+	PlayerKillAssist        func(PlayerKillAssistEvent)
+	PlayerDomination        func(PlayerDominationEvent)
+	PlayerRevenge           func(PlayerRevengeEvent)
+	PlayerSuicide           func(PlayerSuicideEvent)
+	PlayerDamaged           func(PlayerDamage)
+	PlayerHealed            func(PlayerHeal)
+	PlayerKilledMedic       func(PlayerTrigger)
+	PlayerUberFinished      func(PlayerData)
+	PlayerChargeReady       func(PlayerChargeReadyEvent)
+	PlayerChargeDeployed    func(PlayerChargeDeployedEvent)
+	PlayerChargeEnded       func(PlayerChargeEndedEvent)
+	PlayerFirstHeal         func(PlayerFirstHealEvent)
+	PlayerLostUberAdvantage func(PlayerLostUberAdvantageEvent)
+	PlayerBuiltObject       func(PlayerBuiltObjectEvent)
+	PlayerKilledObject      func(PlayerKilledObjectEvent)
+	PlayerCarriedObject     func(PlayerCarriedObjectEvent)
+	PlayerDroppedObject     func(PlayerDroppedObjectEvent)
+	ObjectDetonated         func(ObjectDetonatedEvent)
+	PlayerBlockedCapture    func(CPData, PlayerData) // cp blocked by player
+	PlayerItemPickup        func(ItemPickup)
+	TeamPointCapture        func(TeamData)
+	TeamScoreUpdate         func(TeamData)
+	TeamFinalScore          func(TeamFinalScoreEvent)
+	GameOver                func()
+	GamePaused              func(WorldGamePausedEvent)
+	GameUnpaused            func(WorldGameUnpausedEvent)
+	IntermissionWinLimit    func(WorldIntermissionWinLimitEvent)
+	WorldRoundWin           func(string) // string is team which won
+	WorldRoundStart         func(WorldRoundStartEvent)
+	WorldRoundSetupBegin    func(WorldRoundSetupBeginEvent)
+	WorldRoundSetupEnd      func(WorldRoundSetupEndEvent)
+	WorldRoundOvertime      func(WorldRoundOvertimeEvent)
+	WorldRoundLength        func(WorldRoundLengthEvent)
+	WorldRoundStalemate     func(WorldRoundStalemateEvent)
+	CVarChange              func(value string, variable string)
+	LogFileStarted          func(LogFileStartedEvent)
+	LogFileClosed           func()
+	MapLoading              func(MapLoadingEvent)
+	MapStarted              func(MapStartedEvent)
+	TournamentStarted       func()
+	TournamentStart         func(TournamentStartedEvent) // TournamentStarted with the team names and map
+	RconCommand             func(from, command string)   // from - IP Address, command - command executed
+	Unhandled               func(UnknownEvent)           // messages without an event type of their own
 
 	success chan struct{}
 }
 
 type Listener struct {
-	mapMu    *sync.RWMutex
-	sources  map[string]*Source
-	channels map[string](chan string)
+	mapMu       *sync.RWMutex
+	sources     map[string]*Source
+	addrSources map[string]*Source // sources of packets without a secret, by sender
 
+	conn         *net.UDPConn
 	listenAddr   *net.UDPAddr
 	redirectAddr string
 	print        bool
+	queueConfig  QueueConfig    // protected by mapMu
+	logRetention LogRetention   // protected by mapMu
+	logFiles     LogFileConfig  // protected by mapMu
+	secretLength int            // protected by mapMu, 0 for DefaultSecretLength
+	location     *time.Location // protected by mapMu, nil for UTC
+	closing      bool           // protected by mapMu
+
+	rejectHook func(RejectedPacket) // protected by mapMu
+	rejected   uint64
+
+	readDone chan struct{}  // closed when start returns
+	workers  sync.WaitGroup // one per source delivering lines
 }
 
+var errListenerClosed = errors.New("listener closed")
+
 type Source struct {
 	Secret string
-	logsMu *sync.RWMutex //protects logs
-	logs   *bytes.Buffer
+	Addr   string // the server's address if it sends logs without a secret
+	logs   *logStore
+	files  *logFiles
 
 	handler *EventListener
 	closed  *int32
 
-	//fields used for test only
-	test bool
+	queue    chan []byte // lines waiting for the handler, see deliver
+	overflow OverflowPolicy
+	dropped  uint64
+	done     chan struct{}
+	stopOnce sync.Once
+
+	subsMu sync.Mutex // protects subs, which is nil once the source is removed
+	subs   map[<-chan Event]*subscription
+
+	stateMu    sync.Mutex // protects mapName, tournament and location
+	mapName    string
+	tournament *TournamentStartedEvent // waiting for its team names
+	location   *time.Location          // the server's time zone, nil for UTC
+
 	rcon *TF2RconConnection
-}
 
-func (s *Source) Logs() *bytes.Buffer {
-	s.logsMu.RLock()
-	slice := make([]byte, s.logs.Len())
-	s.logs.Read(slice)
-	logs := bytes.NewBuffer(slice)
-	s.logsMu.RUnlock()
+	addrMu    sync.RWMutex // protects serverIPs and lookupErr
+	serverIPs []net.IP     // where log packets may come from, any if empty
+	lookupErr error        // why serverIPs couldn't be found, none are accepted
+	rejected  uint64
 
-	return logs
+	//fields used for test only
+	test bool
 }
 
 // NewListener returns a new Listener
@@ -82,45 +135,140 @@ func NewListenerAddr(port, redirectAddr string, print bool) (*Listener, error) {
 		return nil, err
 	}
 
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
 	l := &Listener{
-		mapMu:    new(sync.RWMutex),
-		sources:  make(map[string]*Source),
-		channels: make(map[string](chan string)),
+		mapMu:       new(sync.RWMutex),
+		sources:     make(map[string]*Source),
+		addrSources: make(map[string]*Source),
 
+		conn:         conn,
 		listenAddr:   addr,
 		redirectAddr: redirectAddr,
 		print:        print,
+		readDone:     make(chan struct{}),
 	}
 
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		return nil, err
+	go l.start()
+	return l, nil
+}
+
+// Shutdown stops the listener gracefully. It stops reading packets, sends
+// logaddress_del to the server of every source, lets the handlers finish the
+// lines already received and removes all sources. It returns once everything
+// has exited, or with ctx's error if that takes longer than ctx allows, in
+// which case the lines not handled yet are dropped.
+func (l *Listener) Shutdown(ctx context.Context) error {
+	return l.shutdown(ctx, true)
+}
+
+// Close stops the listener without contacting the servers. Lines not handled
+// yet are dropped. It returns once the handlers still running have returned.
+func (l *Listener) Close() error {
+	return l.shutdown(context.Background(), false)
+}
+
+func (l *Listener) shutdown(ctx context.Context, graceful bool) error {
+	l.mapMu.Lock()
+	if l.closing {
+		l.mapMu.Unlock()
+		return errListenerClosed
+	}
+	l.closing = true
+	sources := make([]*Source, 0, len(l.sources)+len(l.addrSources))
+	for _, s := range l.sources {
+		sources = append(sources, s)
 	}
+	for _, s := range l.addrSources {
+		sources = append(sources, s)
+	}
+	l.sources = make(map[string]*Source)
+	l.addrSources = make(map[string]*Source)
+	l.mapMu.Unlock()
 
-	go l.start(conn)
-	return l, nil
+	l.conn.Close()
+	<-l.readDone
+	// nothing is queueing lines any more
+
+	for _, s := range sources {
+		if !graceful {
+			s.stop()
+			continue
+		}
+		if s.rcon != nil && !s.test {
+			s.rcon.StopLogRedirectionContext(ctx, l.redirectAddr)
+		}
+		close(s.queue)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		l.workers.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		for _, s := range sources {
+			s.stop()
+		}
+		return ctx.Err()
+	}
 }
 
 func (l *Listener) RemoveSource(s *Source, m *TF2RconConnection) {
-	atomic.StoreInt32(s.closed, 1)
+	s.stop()
 
 	l.mapMu.Lock()
-	delete(l.sources, s.Secret)
+	l.forget(s)
 	l.mapMu.Unlock()
 
-	m.StopLogRedirection(l.redirectAddr)
+	if m != nil {
+		if err := m.StopLogRedirection(l.redirectAddr); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// forget removes s from the listener, unless another source has taken its
+// secret or address since. l.mapMu must be held.
+func (l *Listener) forget(s *Source) {
+	sources, key := l.sources, s.Secret
+	if s.Addr != "" {
+		sources, key = l.addrSources, s.Addr
+	}
+	if sources[key] == s {
+		delete(sources, key)
+	}
 }
 
-func (l *Listener) start(conn *net.UDPConn) {
+func (l *Listener) start() {
+	defer close(l.readDone)
+
 	for {
 		buff := make([]byte, 2048)
-		n, err := conn.Read(buff)
+		n, from, err := l.conn.ReadFromUDP(buff)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			log.Println(err)
+			continue
+		}
+
+		if n > 4 && buff[4] == 'R' {
+			l.handleUnsecreted(buff[:n], from)
+			continue
 		}
 
 		secret, Lpos, err := getSecret(buff[0:n])
 		if err != nil {
+			l.reject(RejectedPacket{From: from, Reason: RejectInvalid})
 			continue
 		}
 
@@ -133,101 +281,233 @@ func (l *Listener) start(conn *net.UDPConn) {
 		l.mapMu.RUnlock()
 
 		if !ok {
+			l.reject(RejectedPacket{From: from, Reason: RejectUnknownSecret, Secret: secret})
+			continue
+		}
+		if !source.accepts(from.IP) {
+			l.reject(RejectedPacket{From: from, Reason: RejectWrongAddress, Secret: secret, Source: source})
 			continue
 		}
 
-		go func() {
-			if atomic.LoadInt32(source.closed) == 1 {
-				return
-			}
+		source.enqueue(buff[Lpos : n-1])
+	}
+}
 
-			handler := source.handler
+// process handles one log line from s's server
+func (l *Listener) process(s *Source, line []byte) {
+	if s.test {
+		l.mapMu.Lock()
+		l.forget(s)
+		l.mapMu.Unlock()
 
-			if source.test {
-				l.mapMu.Lock()
-				delete(l.sources, source.Secret)
-				l.mapMu.Unlock()
+		s.stop()
+		s.handler.success <- struct{}{}
 
-				atomic.StoreInt32(source.closed, 1)
+		s.rcon.StopLogRedirection(l.redirectAddr)
+		s.rcon.Close()
+		return
+	}
+
+	s.logs.write(line)
+
+	m, err := s.parseLine(line)
+	if err != nil && l.print {
+		log.Println(err)
+	}
+	s.writeLogFile(line, m)
+	if m.Parsed.Event != nil {
+		s.handle(m.Parsed.Event)
+	}
+}
 
-				handler.success <- struct{}{}
+// parseLine parses a log line, with its trailing newline, reading its
+// timestamp in the server's time zone
+func (s *Source) parseLine(line []byte) (LogMessage, error) {
+	s.stateMu.Lock()
+	loc := s.location
+	s.stateMu.Unlock()
 
-				source.rcon.StopLogRedirection(l.redirectAddr)
-				source.rcon.Close()
+	return ParseLogEntryE(string(line[:len(line)-1]), loc)
+}
+
+// SetLocation sets the time zone of s's server, which its log timestamps are
+// in. nil means UTC.
+func (s *Source) SetLocation(loc *time.Location) {
+	s.stateMu.Lock()
+	s.location = loc
+	s.stateMu.Unlock()
+}
+
+// handle passes an event from the source's server to its handler, keeping
+// track of the state needed to complete events logged over several lines
+func (s *Source) handle(e Event) {
+	var pending []Event
+
+	s.stateMu.Lock()
+	if s.tournament != nil {
+		if team, ok := e.(TournamentTeamEvent); ok {
+			s.tournament.setTeamName(team)
+			if s.tournament.BlueTeam == "" || s.tournament.RedTeam == "" {
+				s.stateMu.Unlock()
 				return
 			}
+			e = nil
+		}
+		// the team names never came, pass on what we have
+		pending = append(pending, *s.tournament)
+		s.tournament = nil
+	}
 
-			source.logsMu.Lock()
-			source.logs.Write(buff[Lpos : n-1])
-			source.logsMu.Unlock()
+	switch ev := e.(type) {
+	case MapLoadingEvent:
+		s.mapName = ev.Map
+	case MapStartedEvent:
+		s.mapName = ev.Map
+	case TournamentStartedEvent:
+		ev.Map = s.mapName
+		if ev.BlueTeam == "" || ev.RedTeam == "" {
+			s.tournament = &ev
+			e = nil
+		} else {
+			e = ev
+		}
+	}
+	s.stateMu.Unlock()
 
-			m := ParseLogEntry(string(buff[Lpos : n-2]))
-			m.Parsed.CallHandler(handler)
-		}()
+	if e != nil {
+		pending = append(pending, e)
+	}
+	for _, e := range pending {
+		s.dispatch(e)
 	}
 }
 
-func (l *Listener) getSecret() string {
-	secret := strconv.FormatUint(uint64(rand.Int63()+1), 10)
-	rand.Seed(time.Now().Unix())
+// flush passes on an event still waiting for the lines completing it when s
+// is removed
+func (s *Source) flush() {
+	s.stateMu.Lock()
+	tournament := s.tournament
+	s.tournament = nil
+	s.stateMu.Unlock()
 
-	l.mapMu.RLock()
-	_, ok := l.sources[secret]
-	for ok {
-		secret = strconv.Itoa(100000 + rand.Intn(800000))
-		_, ok = l.sources[secret]
+	if tournament != nil {
+		s.dispatch(*tournament)
 	}
-	l.mapMu.RUnlock()
+}
 
-	return secret
+func (s *Source) dispatch(e Event) {
+	if s.handler != nil {
+		s.handler.HandleEvent(e)
+	}
+	s.publish(e)
 }
 
 func (l *Listener) TestSource(m *TF2RconConnection) bool {
-	secret := l.getSecret()
 	e := &EventListener{success: make(chan struct{}, 1)}
+	ips, lookupErr := lookupServerIPs(m)
 
-	s := newSource(secret, e, true)
-	s.rcon = m
 	l.mapMu.Lock()
+	if l.closing {
+		l.mapMu.Unlock()
+		return false
+	}
+	secret := l.newSecret()
+	s := newSource(secret, e, true, l.queueConfig)
+	s.rcon = m
+	s.serverIPs, s.lookupErr = ips, lookupErr
 	l.sources[secret] = s
+	l.startDelivery(s)
 	l.mapMu.Unlock()
 
-	m.Query("sv_logsecret " + secret)
+	m.SetLogSecret(secret)
 	m.RedirectLogs(l.redirectAddr)
 
 	tick := time.After(5 * time.Second)
 	select {
 	case <-tick:
+		l.mapMu.Lock()
+		l.forget(s)
+		l.mapMu.Unlock()
+		s.stop()
 		return false
 	case <-e.success:
 		return true
 	}
 }
 
+// AddSource redirects m's server's logs to the listener and returns the
+// Source they arrive at. handler may be nil if the events are only read
+// through Source.Subscribe. The source's secret is generated randomly, see
+// SetSecretLength. Packets are only accepted from the address of m's host,
+// see Source.SetServerIP. If it can't be looked up, none are accepted until
+// SetServerIP is called.
 func (l *Listener) AddSource(handler *EventListener, m *TF2RconConnection) *Source {
-	secret := l.getSecret()
-	return l.AddSourceSecret(secret, handler, m)
+	return l.addSource("", handler, m)
 }
 
+// AddSourceSecret is like AddSource, with the given secret. A source already
+// using secret is stopped and replaced.
 func (l *Listener) AddSourceSecret(secret string, handler *EventListener, m *TF2RconConnection) *Source {
-	s := newSource(secret, handler, false)
+	return l.addSource(secret, handler, m)
+}
+
+// addSource adds a source with secret, or with a new secret if it's empty
+func (l *Listener) addSource(secret string, handler *EventListener, m *TF2RconConnection) *Source {
+	ips, lookupErr := lookupServerIPs(m)
 
 	l.mapMu.Lock()
+	if secret == "" {
+		secret = l.newSecret()
+	}
+	s := newSource(secret, handler, false, l.queueConfig)
+	s.rcon = m
+	s.serverIPs, s.lookupErr = ips, lookupErr
+	l.configureSource(s)
+	if l.dropIfClosing(s) {
+		l.mapMu.Unlock()
+		return s
+	}
+	if old, ok := l.sources[secret]; ok {
+		// Shutdown only waits for the sources it can find
+		old.stop()
+	}
 	l.sources[secret] = s
+	l.startDelivery(s)
 	l.mapMu.Unlock()
 
-	m.Query("sv_logsecret " + secret)
+	m.SetLogSecret(secret)
 	m.RedirectLogs(l.redirectAddr)
 	return s
 }
 
-func newSource(secret string, handler *EventListener, test bool) *Source {
+// configureSource applies the listener's settings to a new source. l.mapMu
+// must be held. The directories were checked by the listener's setters, so
+// errors here mean they have gone since and are only logged.
+func (l *Listener) configureSource(s *Source) {
+	s.location = l.location
+	if err := s.SetLogRetention(l.logRetention); err != nil {
+		log.Println(err)
+	}
+	if err := s.SetLogFiles(l.logFiles); err != nil {
+		log.Println(err)
+	}
+}
+
+func newSource(secret string, handler *EventListener, test bool, config QueueConfig) *Source {
+	if config.Size <= 0 {
+		config.Size = DefaultQueueSize
+	}
+
 	return &Source{
-		Secret:  secret,
-		logsMu:  new(sync.RWMutex),
-		logs:    new(bytes.Buffer),
-		handler: handler,
-		closed:  new(int32),
-		test:    test,
+		Secret:   secret,
+		logs:     newLogStore(),
+		files:    new(logFiles),
+		handler:  handler,
+		closed:   new(int32),
+		queue:    make(chan []byte, config.Size),
+		overflow: config.Overflow,
+		done:     make(chan struct{}),
+		subs:     make(map[<-chan Event]*subscription),
+		test:     test,
 	}
 }
@@ -0,0 +1,414 @@
+package TF2RconWrapper
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feed passes log lines to s one at a time, as separate packets would
+func feed(s *Source, lines ...string) {
+	for _, line := range lines {
+		s.handle(ParseEvent(line))
+	}
+}
+
+func TestSourceTournamentStarted(t *testing.T) {
+	var started []TournamentStartedEvent
+	var rounds, legacy int
+	s := newSource("1234", &EventListener{
+		TournamentStarted: func() { legacy++ },
+		TournamentStart:   func(e TournamentStartedEvent) { started = append(started, e) },
+		WorldRoundStart:   func(WorldRoundStartEvent) { rounds++ },
+	}, false, QueueConfig{})
+
+	feed(s,
+		`Loading map "cp_badlands"`,
+		`Started map "cp_badlands" (CRC "2e1d2f6f3c8a4b5e")`,
+		`Tournament mode started`,
+		`Blue Team: BLU`,
+	)
+	assert.Empty(t, started)
+
+	feed(s, `Red Team: RED`)
+	require.Len(t, started, 1)
+	assert.Equal(t, TournamentStartedEvent{BlueTeam: "BLU", RedTeam: "RED", Map: "cp_badlands"}, started[0])
+
+	// a missing team line doesn't hold back the messages after it
+	feed(s,
+		`Tournament mode started`,
+		`Red Team: Team Soup`,
+		`World triggered "Round_Start"`,
+	)
+	require.Len(t, started, 2)
+	assert.Equal(t, TournamentStartedEvent{RedTeam: "Team Soup", Map: "cp_badlands"}, started[1])
+	assert.Equal(t, 1, rounds)
+
+	// the whole sequence in one message
+	feed(s, "Tournament mode started\nBlue Team: BLU\nRed Team: RED")
+	require.Len(t, started, 3)
+	assert.Equal(t, started[0], started[2])
+	assert.Equal(t, 3, legacy)
+}
+
+func TestSourceTournamentStartedRemoved(t *testing.T) {
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+	var started []TournamentStartedEvent
+	s := newSource("1234", &EventListener{
+		TournamentStart: func(e TournamentStartedEvent) { started = append(started, e) },
+	}, false, QueueConfig{})
+	events := s.Subscribe(nil, SubscriptionConfig{})
+
+	feed(s, `Tournament mode started`, `Blue Team: BLU`)
+	assert.Empty(t, started)
+
+	// the source is removed before the red team's line arrives
+	s.stop()
+	l.deliver(s)
+
+	want := TournamentStartedEvent{BlueTeam: "BLU"}
+	require.Len(t, started, 1)
+	assert.Equal(t, want, started[0])
+	assert.Equal(t, Event(want), <-events)
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+// chatLine returns a log line, as queued for a source, of a player saying text
+func chatLine(text string) []byte {
+	return []byte(`L 03/09/2016 - 02:50:52: "Sk1LL0<2><[U:1:198288660]><Red>" say "` + text + "\"\n")
+}
+
+func TestSourceOrderedDelivery(t *testing.T) {
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+
+	const lines = 2000
+	var mu sync.Mutex
+	said := make(map[string][]string)
+	done := make(chan struct{}, 2)
+	newChatSource := func(secret string) *Source {
+		return newSource(secret, &EventListener{
+			PlayerGlobalMessage: func(_ PlayerData, text string) {
+				mu.Lock()
+				said[secret] = append(said[secret], text)
+				if len(said[secret]) == lines {
+					done <- struct{}{}
+				}
+				mu.Unlock()
+			},
+		}, false, QueueConfig{Size: lines, Overflow: OverflowBlock})
+	}
+
+	a, b := newChatSource("a"), newChatSource("b")
+	go l.deliver(a)
+	go l.deliver(b)
+	defer a.stop()
+	defer b.stop()
+
+	for i := 0; i < lines; i++ {
+		a.enqueue(chatLine(fmt.Sprint(i)))
+		b.enqueue(chatLine(fmt.Sprint(i)))
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("lines weren't delivered")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, secret := range []string{"a", "b"} {
+		require.Len(t, said[secret], lines)
+		for i, text := range said[secret] {
+			require.Equal(t, fmt.Sprint(i), text, secret)
+		}
+	}
+	assert.Equal(t, uint64(0), a.Dropped())
+	assert.Contains(t, a.Logs().String(), `say "1999"`)
+}
+
+func TestSourceOverflow(t *testing.T) {
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+
+	for _, c := range []struct {
+		policy   OverflowPolicy
+		expected []string
+	}{
+		{OverflowDropNewest, []string{"0", "1", "2", "3"}},
+		{OverflowDropOldest, []string{"0", "4", "5", "6"}},
+	} {
+		// the handler holds the first line until every line has been queued
+		release := make(chan struct{})
+		said := make(chan string, 10)
+		s := newSource("1234", &EventListener{
+			PlayerGlobalMessage: func(_ PlayerData, text string) {
+				if text == "0" {
+					<-release
+				}
+				said <- text
+			},
+		}, false, QueueConfig{Size: 3, Overflow: c.policy})
+		go l.deliver(s)
+
+		s.enqueue(chatLine("0"))
+		for len(s.queue) != 0 {
+			time.Sleep(time.Millisecond)
+		}
+		for i := 1; i < 7; i++ {
+			s.enqueue(chatLine(fmt.Sprint(i)))
+		}
+		assert.Equal(t, uint64(3), s.Dropped())
+		close(release)
+
+		var got []string
+		for len(got) < len(c.expected) {
+			select {
+			case text := <-said:
+				got = append(got, text)
+			case <-time.After(5 * time.Second):
+				t.Fatal("lines weren't delivered")
+			}
+		}
+		assert.Equal(t, c.expected, got)
+		s.stop()
+	}
+}
+
+// sendLines sends log lines to l as a server would, with secret
+func sendLines(t *testing.T, l *Listener, secret string, lines ...[]byte) {
+	port := l.conn.LocalAddr().(*net.UDPAddr).Port
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, line := range lines {
+		packet := append([]byte("\xff\xff\xff\xffS"+secret), line...)
+		_, err := conn.Write(append(packet, 0))
+		require.NoError(t, err)
+	}
+}
+
+func TestListenerShutdown(t *testing.T) {
+	cmds := make(chan string, 10)
+	server := newFakeServer(t, "pass", func(cmd string) []string {
+		cmds <- cmd
+		return []string{""}
+	})
+	defer server.Close()
+	c, err := NewTF2RconConnection(server.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+
+	l, err := NewListenerAddr("0", "127.0.0.1:1234", false)
+	require.NoError(t, err)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var said []string
+	s := l.AddSourceSecret("1234567", &EventListener{
+		PlayerGlobalMessage: func(_ PlayerData, text string) {
+			if text == "0" {
+				close(started)
+				<-release
+			}
+			said = append(said, text)
+		},
+	}, c)
+	assert.Equal(t, "sv_logsecret 1234567", <-cmds)
+	assert.Equal(t, "logaddress_add 127.0.0.1:1234", <-cmds)
+
+	sendLines(t, l, "1234567", chatLine("0"), chatLine("1"), chatLine("2"))
+	<-started
+	for len(s.queue) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdown := make(chan error)
+	go func() { shutdown <- l.Shutdown(context.Background()) }()
+
+	assert.Equal(t, "logaddress_del 127.0.0.1:1234", <-cmds)
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned before the queued lines were handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-shutdown)
+	assert.Equal(t, []string{"0", "1", "2"}, said)
+
+	l.mapMu.RLock()
+	assert.Empty(t, l.sources)
+	l.mapMu.RUnlock()
+	assert.Equal(t, errListenerClosed, l.Close())
+}
+
+func TestListenerShutdownTimeout(t *testing.T) {
+	l, err := NewListenerAddr("0", "127.0.0.1:1234", false)
+	require.NoError(t, err)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handled := 0
+	l.mapMu.Lock()
+	s := newSource("1234567", &EventListener{
+		PlayerGlobalMessage: func(PlayerData, string) {
+			if handled == 0 {
+				close(started)
+				<-release
+			}
+			handled++
+		},
+	}, false, QueueConfig{})
+	l.sources[s.Secret] = s
+	l.startDelivery(s)
+	l.mapMu.Unlock()
+
+	sendLines(t, l, "1234567", chatLine("0"), chatLine("1"))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.Shutdown(ctx))
+
+	// the line being handled finishes, the rest are dropped
+	close(release)
+	l.workers.Wait()
+	assert.Equal(t, 1, handled)
+}
+
+func TestListenerDuplicateSecret(t *testing.T) {
+	server := newFakeServer(t, "pass", func(string) []string { return []string{""} })
+	defer server.Close()
+	c, err := NewTF2RconConnection(server.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+
+	l, err := NewListenerAddr("0", "127.0.0.1:1234", false)
+	require.NoError(t, err)
+
+	first := l.AddSourceSecret("1234567", nil, c)
+	second := l.AddSourceSecret("1234567", nil, c)
+
+	// the replaced source is stopped, its delivery goroutine with it
+	assert.Equal(t, int32(1), atomic.LoadInt32(first.closed))
+	l.mapMu.RLock()
+	assert.Equal(t, second, l.sources["1234567"])
+	l.mapMu.RUnlock()
+
+	// removing the replaced source leaves the one using its secret now
+	l.RemoveSource(first, nil)
+	l.mapMu.RLock()
+	assert.Equal(t, second, l.sources["1234567"])
+	l.mapMu.RUnlock()
+
+	closed := make(chan error)
+	go func() { closed <- l.Close() }()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the replaced source")
+	}
+}
+
+func TestSourceLocation(t *testing.T) {
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+	cet := time.FixedZone("CET", 60*60)
+	l.SetLocation(cet)
+
+	s := newSource("1234", nil, false, QueueConfig{})
+	l.configureSource(s)
+	m, err := s.parseLine(chatLine("hi"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2016, 3, 9, 1, 50, 52, 0, time.UTC), m.Timestamp.UTC())
+
+	s.SetLocation(nil)
+	m, err = s.parseLine(chatLine("hi"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2016, 3, 9, 2, 50, 52, 0, time.UTC), m.Timestamp)
+}
+
+func TestSourceSubscribe(t *testing.T) {
+	s := newSource("1234", nil, false, QueueConfig{})
+
+	chat := s.Subscribe(func(e Event) bool {
+		_, ok := e.(PlayerGlobalMessageEvent)
+		return ok
+	}, SubscriptionConfig{})
+	all := s.Subscribe(nil, SubscriptionConfig{Buffer: 2})
+	unsubscribed := s.Subscribe(nil, SubscriptionConfig{})
+
+	s.Unsubscribe(unsubscribed)
+	_, ok := <-unsubscribed
+	assert.False(t, ok)
+
+	feed(s,
+		`"Sk1LL0<2><[U:1:198288660]><Red>" say "hi"`,
+		`World triggered "Round_Start"`,
+		`"Sk1LL0<2><[U:1:198288660]><Red>" say "gl hf"`,
+	)
+
+	for _, text := range []string{"hi", "gl hf"} {
+		e := <-chat
+		require.IsType(t, PlayerGlobalMessageEvent{}, e)
+		assert.Equal(t, text, e.(PlayerGlobalMessageEvent).Text)
+	}
+
+	// all only had room for two events, the newest was dropped
+	assert.IsType(t, PlayerGlobalMessageEvent{}, <-all)
+	assert.IsType(t, WorldRoundStartEvent{}, <-all)
+
+	// removing the source closes every subscription
+	s.closeSubscriptions()
+	_, ok = <-chat
+	assert.False(t, ok)
+	_, ok = <-all
+	assert.False(t, ok)
+
+	_, ok = <-s.Subscribe(nil, SubscriptionConfig{})
+	assert.False(t, ok)
+}
+
+func TestSourceSubscribeBlock(t *testing.T) {
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+	s := newSource("1234", nil, false, QueueConfig{})
+	ch := s.Subscribe(nil, SubscriptionConfig{Buffer: 1, Overflow: OverflowBlock})
+	go l.deliver(s)
+	defer s.stop()
+
+	for i := 0; i < 5; i++ {
+		s.enqueue(chatLine(fmt.Sprint(i)))
+	}
+
+	// nothing is dropped however slowly the subscriber reads
+	for i := 0; i < 5; i++ {
+		time.Sleep(5 * time.Millisecond)
+		e := <-ch
+		assert.Equal(t, fmt.Sprint(i), e.(PlayerGlobalMessageEvent).Text)
+	}
+
+	// unsubscribing releases a blocked source
+	s.enqueue(chatLine("5"))
+	s.enqueue(chatLine("6"))
+	for len(s.queue) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	s.Unsubscribe(ch)
+
+	other := s.Subscribe(nil, SubscriptionConfig{})
+	s.enqueue(chatLine("7"))
+	select {
+	case e := <-other:
+		assert.Equal(t, "7", e.(PlayerGlobalMessageEvent).Text)
+	case <-time.After(5 * time.Second):
+		t.Fatal("source stayed blocked")
+	}
+}
@@ -0,0 +1,40 @@
+package TF2RconWrapper
+
+// Object is a building an engineer (or a spy's sapper) can place
+type Object int
+
+const (
+	ObjectUnknown Object = iota
+	ObjectDispenser
+	ObjectTeleporter
+	ObjectSentryGun
+	ObjectSapper
+)
+
+var objectNames = map[string]Object{
+	"OBJ_DISPENSER":         ObjectDispenser,
+	"OBJ_TELEPORTER":        ObjectTeleporter,
+	"OBJ_SENTRYGUN":         ObjectSentryGun,
+	"OBJ_ATTACHMENT_SAPPER": ObjectSapper,
+}
+
+// ParseObject parses an object name as logged, like "OBJ_SENTRYGUN"
+func ParseObject(s string) (Object, bool) {
+	o, ok := objectNames[s]
+	return o, ok
+}
+
+// String returns the object's name as logged
+func (o Object) String() string {
+	switch o {
+	case ObjectDispenser:
+		return "OBJ_DISPENSER"
+	case ObjectTeleporter:
+		return "OBJ_TELEPORTER"
+	case ObjectSentryGun:
+		return "OBJ_SENTRYGUN"
+	case ObjectSapper:
+		return "OBJ_ATTACHMENT_SAPPER"
+	}
+	return "unknown"
+}
@@ -0,0 +1,305 @@
+package TF2RconWrapper
+
+import (
+	"bufio"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// "Username<userId><steamId><Team>"
+	// "1<2><3><4>" <- regex group
+	logLineStart = `^"(.*)<(\d+)><(\[U:1:\d+\])><(\w+)>" `
+	player       = `"(.*)<(\d+)><(\[U:1:\d+\])><(\w+)>"`
+	// "5" <- regex group
+	logLineEnd = ` "(.*)"`
+
+	logLineStartSpec = `^"(.*)<(\d+)><(\[U:1:\d+\])><(\w*)>" `
+)
+
+var (
+	rPlayerGlobalMessage  = regexp.MustCompile(logLineStart + `say` + logLineEnd)
+	rPlayerChangedClass   = regexp.MustCompile(logLineStart + `changed role to "(scout|soldier|pyro|engineer|heavyweapons|demoman|sniper|medic|spy)"`)
+	rPlayerTeamMessage    = regexp.MustCompile(logLineStart + `say_team` + logLineEnd)
+	rPlayerChangedTeam    = regexp.MustCompile(logLineStart + `joined team` + logLineEnd)
+	rPlayerPickedUp       = regexp.MustCompile(logLineStart + `picked up item "(\w+)"(?: \(healing "(\d+)"\))*`)
+	rPlayerSpawned        = regexp.MustCompile(logLineStart + `spawned as "(\w+)"`)
+	rPlayerKilled         = regexp.MustCompile(logLineStart + `killed ` + player + ` with "(\w+)"(?: \(customkill "(\w+)"\)){0,1} \(attacker_position (".+")\) \(victim_position (".+")\)`)
+	rPlayerDamage         = regexp.MustCompile(logLineStart + `triggered "damage" against ` + player + ` \(damage "(\d+)"\)(?: \(realdamage "\d+"\)){0,1} \(weapon "(\w+)"\)(?: \((airshot) "1"\)){0,1}(?: \((headshot) "1"\)){0,1}`)
+	rPlayerHeal           = regexp.MustCompile(logLineStart + `triggered "healed" against ` + player + ` \(healing "(\d+)"\)`)
+	rPlayerKilledMedic    = regexp.MustCompile(logLineStart + `triggered "medic_death" against ` + player + ` \(healing "(\d+)"\) \(ubercharge "(\d+)"\)`)
+	rPlayerUberFinished   = regexp.MustCompile(logLineStart + `triggered "empty_uber"`)
+	rPlayerBlockedCapture = regexp.MustCompile(logLineStart + `triggered "captureblocked" \(cp "(\d+)"\) \(cpname "(#\w+)"\) \(position "(.+)"\)`)
+	rPlayerConnected      = regexp.MustCompile(logLineStartSpec + `connected, address "(\d+.\d+.\d+.\d+\:\d+)"`)
+	rPlayerDisconnected   = regexp.MustCompile(logLineStartSpec + `disconnected \(reason "(.*)"\)`)
+
+	//Team events
+	rTeamPointCapture = regexp.MustCompile(`^Team "(Red|Blue)" triggered "pointcaptured" \(cp "(\d+)"\) \(cpname "(#\w+)"\)`)
+	rTeamScoreUpdate  = regexp.MustCompile(`^Team "(Red|Blue)" current score "(\d+)" with "(\d+)" players`)
+
+	//World events
+	rGameOver   = regexp.MustCompile(`^World triggered "Game_Over" reason "(.*)"`)
+	rRoundWin   = regexp.MustCompile(`^World triggered "Round_Win" \(winner "(Red|Blue)"\)`)
+	rRoundStart = regexp.MustCompile(`^World triggered "Round_Start"`)
+	rServerCvar = regexp.MustCompile(`^server_cvar: "(.*)" "(.*)"`)
+
+	rTournamentStarted = regexp.MustCompile(`^Tournament mode started\nBlue Team: \w+\nRed Team: \w`)
+	rLogFiledClosed    = regexp.MustCompile("^Log file closed.")
+
+	rRconCommand = regexp.MustCompile(`^rcon from "(.+)": command "(.+)"`)
+)
+
+func getPlayerData(matches []string, from int, includeTeam bool) PlayerData {
+	d := PlayerData{
+		Username: matches[from+0],
+		UserId:   matches[from+1],
+		SteamId:  mustParseSteamID(matches[from+2]),
+	}
+
+	if includeTeam {
+		d.Team = matches[from+3]
+	}
+
+	return d
+}
+
+// parseEventRegexp is the regular expression based parser ParseEvent
+// replaced, kept to check the two agree and to compare their speed
+func parseEventRegexp(message string) Event {
+	switch {
+	case rPlayerKilled.MatchString(message):
+		m := rPlayerKilled.FindStringSubmatch(message)
+
+		kill := PlayerKill{
+			PlayerTrigger: PlayerTrigger{
+				Player1: getPlayerData(m, 1, true),
+				Player2: getPlayerData(m, 5, true),
+			},
+		}
+
+		kill.Weapon = m[9]
+		if len(m) > 10 {
+			kill.CustomKill = m[10]
+		}
+		kill.AttackerPosition, _ = ParsePosition(strings.Trim(m[11], `"`))
+		kill.VictimPosition, _ = ParsePosition(strings.Trim(m[12], `"`))
+
+		return PlayerKilledEvent{LogProperties{}, kill}
+
+	case rPlayerDamage.MatchString(message):
+		m := rPlayerDamage.FindStringSubmatch(message)
+
+		dmg, _ := strconv.Atoi(m[9])
+		damage := PlayerDamage{
+			PlayerTrigger: PlayerTrigger{
+				Player1: getPlayerData(m, 1, true), // ends at m[4]
+				Player2: getPlayerData(m, 5, true), // ends at m[8]
+			},
+
+			Damage:   dmg,
+			Weapon:   m[10],
+			Airshot:  m[11] == "airshot",
+			Headshot: m[12] == "headshot",
+		}
+
+		return PlayerDamagedEvent{LogProperties{}, damage}
+
+	case rPlayerHeal.MatchString(message):
+		m := rPlayerHeal.FindStringSubmatch(message)
+
+		healing, _ := strconv.Atoi(m[9])
+		heal := PlayerHeal{
+			PlayerTrigger: PlayerTrigger{
+				Player1: getPlayerData(m, 1, true), // end at m[4]
+				Player2: getPlayerData(m, 5, true), // ends at m[8]
+			},
+			Healed: healing,
+		}
+
+		return PlayerHealedEvent{LogProperties{}, heal}
+
+	case rPlayerPickedUp.MatchString(message):
+		m := rPlayerPickedUp.FindStringSubmatch(message)
+
+		playerData := getPlayerData(m, 1, true)
+		pickup := ItemPickup{
+			PlayerData: playerData,
+			Item:       m[5],
+		}
+
+		if len(m) == 7 {
+			pickup.Healing, _ = strconv.Atoi(m[6])
+		}
+
+		return PlayerPickedUpItemEvent{LogProperties{}, pickup}
+
+	case rPlayerGlobalMessage.MatchString(message):
+		m := rPlayerGlobalMessage.FindStringSubmatch(message)
+		return PlayerGlobalMessageEvent{LogProperties{}, getPlayerData(m, 1, true), m[5]}
+
+	case rPlayerTeamMessage.MatchString(message):
+		m := rPlayerTeamMessage.FindStringSubmatch(message)
+		return PlayerTeamMessageEvent{LogProperties{}, getPlayerData(m, 1, true), m[5]}
+
+	case rPlayerChangedClass.MatchString(message):
+		m := rPlayerChangedClass.FindStringSubmatch(message)
+		return PlayerChangedClassEvent{LogProperties{}, getPlayerData(m, 1, true), m[5]}
+
+	case rPlayerChangedTeam.MatchString(message):
+		m := rPlayerChangedTeam.FindStringSubmatch(message)
+		return PlayerChangedTeamEvent{LogProperties{}, getPlayerData(m, 1, true), m[5]}
+
+	case rPlayerSpawned.MatchString(message):
+		m := rPlayerSpawned.FindStringSubmatch(message)
+		return PlayerSpawnedEvent{LogProperties{}, getPlayerData(m, 1, true), m[5]}
+
+	case rPlayerKilledMedic.MatchString(message):
+		m := rPlayerKilledMedic.FindStringSubmatch(message)
+
+		healing, _ := strconv.Atoi(m[9])
+		return PlayerKilledMedicEvent{
+			PlayerTrigger: PlayerTrigger{
+				Player1: getPlayerData(m, 1, true),
+				Player2: getPlayerData(m, 5, true),
+			},
+			Healing:    healing,
+			Ubercharge: m[10] == "1",
+		}
+
+	case rPlayerUberFinished.MatchString(message):
+		m := rPlayerUberFinished.FindStringSubmatch(message)
+		return PlayerUberFinishedEvent{LogProperties{}, getPlayerData(m, 1, true)}
+
+	case rPlayerBlockedCapture.MatchString(message):
+		m := rPlayerBlockedCapture.FindStringSubmatch(message)
+		position, _ := ParsePosition(m[7])
+
+		return PlayerBlockedCaptureEvent{
+			CPData:   CPData{m[5], m[6]},
+			Player:   getPlayerData(m, 1, true),
+			Position: position,
+		}
+
+	case rPlayerConnected.MatchString(message):
+		m := rPlayerConnected.FindStringSubmatch(message)
+		return PlayerConnectedEvent{LogProperties{}, getPlayerData(m, 1, false), m[5]}
+
+	case rPlayerDisconnected.MatchString(message):
+		m := rPlayerDisconnected.FindStringSubmatch(message)
+		return PlayerDisconnectedEvent{LogProperties{}, getPlayerData(m, 1, false), m[5]}
+
+	// Non-Player Messages
+	case rGameOver.MatchString(message):
+		m := rGameOver.FindStringSubmatch(message)
+		return WorldGameOverEvent{LogProperties{}, m[1]}
+
+	case rRoundWin.MatchString(message):
+		m := rRoundWin.FindStringSubmatch(message)
+		return WorldRoundWinEvent{LogProperties{}, m[1]}
+
+	case rServerCvar.MatchString(message):
+		m := rServerCvar.FindStringSubmatch(message)
+		return ServerCvarEvent{LogProperties{}, CvarData{Variable: m[1], Value: m[2]}}
+
+	case rLogFiledClosed.MatchString(message):
+		return LogFileClosedEvent{}
+	case rTournamentStarted.MatchString(message):
+		return TournamentStartedEvent{}
+	case rTeamPointCapture.MatchString(message):
+		m := rTeamPointCapture.FindStringSubmatch(message)
+		return TeamPointCaptureEvent{
+			CPData: CPData{
+				CP:     m[2],
+				CPName: m[3],
+			},
+			Team: m[1],
+		}
+	case rTeamScoreUpdate.MatchString(message):
+		m := rTeamScoreUpdate.FindStringSubmatch(message)
+
+		score, _ := strconv.Atoi(m[2])
+		players, _ := strconv.Atoi(m[3])
+		return TeamScoreUpdateEvent{
+			Team:    m[1],
+			Score:   score,
+			Players: players,
+		}
+
+	case rRconCommand.MatchString(message):
+		m := rRconCommand.FindStringSubmatch(message)
+		return RconCommandEvent{LogProperties{}, m[1], m[2]}
+	}
+
+	return nil
+}
+
+// readCorpus returns the messages of testdata/match.log, a representative
+// 6v6 match log, without their timestamps
+func readCorpus(tb testing.TB) []string {
+	f, err := os.Open("testdata/match.log")
+	require.NoError(tb, err)
+	defer f.Close()
+
+	var messages []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		messages = append(messages, ParseLogEntry(scanner.Text()).Message)
+	}
+	require.NoError(tb, scanner.Err())
+
+	return messages
+}
+
+func TestParseEventMatchesRegexp(t *testing.T) {
+	messages := append(readCorpus(t), logs...)
+
+	for _, message := range messages {
+		if expected := parseEventRegexp(message); expected != nil {
+			assert.Equal(t, expected, withoutProperties(ParseEvent(message)), message)
+		}
+	}
+}
+
+// withoutProperties returns e with its LogProperties cleared, as
+// parseEventRegexp never set them
+func withoutProperties(e Event) Event {
+	if e == nil {
+		return nil
+	}
+
+	v := reflect.New(reflect.TypeOf(e)).Elem()
+	v.Set(reflect.ValueOf(e))
+	v.FieldByName("LogProperties").Set(reflect.ValueOf(LogProperties{}))
+	return v.Interface().(Event)
+}
+
+func BenchmarkParseEvent(b *testing.B) {
+	messages := readCorpus(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, message := range messages {
+			ParseEvent(message)
+		}
+	}
+}
+
+func BenchmarkParseEventRegexp(b *testing.B) {
+	messages := readCorpus(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, message := range messages {
+			parseEventRegexp(message)
+		}
+	}
+}
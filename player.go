@@ -1,10 +1,14 @@
 package TF2RconWrapper
 
+import "time"
+
 type Player struct {
-	UserID   string
-	Username string
-	SteamID  string
-	//Ping     int
-	//State    string
-	Ip string
+	UserID    string
+	Username  string
+	SteamID   SteamID
+	Connected time.Duration
+	Ping      int
+	Loss      int
+	State     string
+	Ip        string
 }
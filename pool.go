@@ -0,0 +1,277 @@
+package TF2RconWrapper
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultPoolSize is the number of connections a TF2RconPool opens when its
+// PoolConfig doesn't set MaxSize
+const DefaultPoolSize = 4
+
+// healthCheckTimeout bounds the query used to check an idle connection
+const healthCheckTimeout = 5 * time.Second
+
+var errPoolClosed = errors.New("RCON pool closed")
+
+// PoolConfig configures a TF2RconPool
+type PoolConfig struct {
+	// MaxSize is the maximum number of connections open at once
+	MaxSize int
+	// IdleTimeout closes connections that haven't been used for this
+	// long. Zero keeps them open.
+	IdleTimeout time.Duration
+	// HealthCheckInterval is how often idle connections are checked with
+	// a query, dropping the ones that fail. Zero disables health checks.
+	HealthCheckInterval time.Duration
+}
+
+// TF2RconPool holds several authenticated rcon connections to the same
+// server, so that independent commands don't have to queue behind each
+// other. Connections are opened as needed, up to MaxSize.
+type TF2RconPool struct {
+	host     string
+	password string
+	config   PoolConfig
+
+	idle  chan *pooledConn
+	slots chan struct{} // holds a token for every open connection
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	done      chan struct{} // closed when the janitor exits
+}
+
+type pooledConn struct {
+	*TF2RconConnection
+	lastUsed    time.Time
+	lastChecked time.Time
+}
+
+// NewTF2RconPool builds a new pool of connections to a server at address
+// ("ip:port") using a rcon_password password. One connection is opened
+// right away to check the address and password.
+func NewTF2RconPool(address, password string, config PoolConfig) (*TF2RconPool, error) {
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultPoolSize
+	}
+
+	p := &TF2RconPool{
+		host:     address,
+		password: password,
+		config:   config,
+		idle:     make(chan *pooledConn, config.MaxSize),
+		slots:    make(chan struct{}, config.MaxSize),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	p.slots <- struct{}{}
+	pc, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	p.idle <- pc
+
+	go p.janitor()
+	return p, nil
+}
+
+// Query executes a query on one of the pool's connections
+func (p *TF2RconPool) Query(req string) (string, error) {
+	return p.QueryContext(context.Background(), req)
+}
+
+// QueryContext executes a query on one of the pool's connections, waiting for
+// one to become available until ctx is done
+func (p *TF2RconPool) QueryContext(ctx context.Context, req string) (string, error) {
+	var resp string
+	err := p.Do(ctx, func(c *TF2RconConnection) error {
+		var err error
+		resp, err = c.QueryContext(ctx, req)
+		return err
+	})
+
+	return resp, err
+}
+
+// Do borrows a connection for the duration of fn, for operations that need
+// several commands to run on one connection, like ExecConfig. The connection
+// must not be used after fn returns.
+func (p *TF2RconPool) Do(ctx context.Context, fn func(*TF2RconConnection) error) error {
+	pc, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(pc.TF2RconConnection)
+	p.put(pc)
+	return err
+}
+
+// Len returns the number of connections currently open
+func (p *TF2RconPool) Len() int {
+	return len(p.slots)
+}
+
+// Close closes every connection in the pool. Connections that are borrowed
+// are closed when they're returned.
+func (p *TF2RconPool) Close() {
+	p.closeOnce.Do(func() { close(p.closed) })
+	<-p.done
+	p.closeIdle()
+}
+
+func (p *TF2RconPool) closeIdle() {
+	for {
+		select {
+		case pc := <-p.idle:
+			p.discard(pc)
+		default:
+			return
+		}
+	}
+}
+
+func (p *TF2RconPool) get(ctx context.Context) (*pooledConn, error) {
+	for {
+		pc, err := p.take(ctx)
+		if err != nil || pc.alive() {
+			return pc, err
+		}
+		// the server dropped it while it was idle
+		p.discard(pc)
+	}
+}
+
+func (p *TF2RconPool) take(ctx context.Context) (*pooledConn, error) {
+	select {
+	case <-p.closed:
+		return nil, errPoolClosed
+	case pc := <-p.idle:
+		return pc, nil
+	default:
+	}
+
+	select {
+	case <-p.closed:
+		return nil, errPoolClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case pc := <-p.idle:
+		return pc, nil
+	case p.slots <- struct{}{}:
+		return p.dial()
+	}
+}
+
+// dial opens a new connection for a slot the caller already took
+func (p *TF2RconPool) dial() (*pooledConn, error) {
+	// broken connections are replaced by the pool instead of reconnecting
+	c, err := newTF2RconConnection(p.host, p.password, ReconnectPolicy{MaxElapsed: -1})
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+
+	now := time.Now()
+	return &pooledConn{c, now, now}, nil
+}
+
+func (p *TF2RconPool) put(pc *pooledConn) {
+	select {
+	case <-p.closed:
+		p.discard(pc)
+		return
+	default:
+	}
+
+	if !pc.alive() {
+		p.discard(pc)
+		return
+	}
+
+	pc.lastUsed = time.Now()
+	p.idle <- pc
+
+	// Close may have drained the idle connections in the meantime
+	select {
+	case <-p.closed:
+		p.closeIdle()
+	default:
+	}
+}
+
+func (p *TF2RconPool) discard(pc *pooledConn) {
+	pc.Close()
+	<-p.slots
+}
+
+// janitor periodically closes idle connections that timed out or fail a
+// health check
+func (p *TF2RconPool) janitor() {
+	defer close(p.done)
+
+	interval := p.config.HealthCheckInterval
+	if interval == 0 || (p.config.IdleTimeout > 0 && p.config.IdleTimeout < interval) {
+		interval = p.config.IdleTimeout
+	}
+	if interval == 0 {
+		<-p.closed
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+		}
+
+		// one connection at a time, so the others stay available while
+		// a health check waits on a slow server
+		for n := len(p.idle); n > 0; n-- {
+			select {
+			case <-p.closed:
+				return
+			case pc := <-p.idle:
+				p.tidy(pc)
+			default:
+				// the rest were borrowed in the meantime
+			}
+		}
+	}
+}
+
+// tidy returns an idle connection to the pool unless it timed out or fails a
+// health check
+func (p *TF2RconPool) tidy(pc *pooledConn) {
+	now := time.Now()
+	if p.config.IdleTimeout > 0 && now.Sub(pc.lastUsed) >= p.config.IdleTimeout {
+		p.discard(pc)
+		return
+	}
+
+	if p.config.HealthCheckInterval > 0 && now.Sub(pc.lastChecked) >= p.config.HealthCheckInterval {
+		pc.lastChecked = now
+		if !p.healthy(pc) {
+			p.discard(pc)
+			return
+		}
+	}
+
+	p.idle <- pc
+}
+
+func (p *TF2RconPool) healthy(pc *pooledConn) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	_, err := pc.QueryContext(ctx, "echo")
+	return err == nil
+}
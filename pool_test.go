@@ -0,0 +1,214 @@
+package TF2RconWrapper
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolQuery(t *testing.T) {
+	var running, maxRunning int32
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		return []string{cmd}
+	})
+	defer s.Close()
+
+	p, err := NewTF2RconPool(s.Addr(), "pass", PoolConfig{MaxSize: 3})
+	require.NoError(t, err)
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 18; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req := "sm_whitelist_add " + strconv.Itoa(i)
+			resp, err := p.Query(req)
+			assert.NoError(t, err)
+			assert.Equal(t, req, resp)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 3, p.Len())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&maxRunning))
+}
+
+func TestPoolWait(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{cmd}
+	})
+	defer s.Close()
+
+	p, err := NewTF2RconPool(s.Addr(), "pass", PoolConfig{MaxSize: 1})
+	require.NoError(t, err)
+	defer p.Close()
+
+	release := make(chan struct{})
+	go p.Do(context.Background(), func(c *TF2RconConnection) error {
+		<-release
+		return nil
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = p.QueryContext(ctx, "echo")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(release)
+	resp, err := p.Query("echo")
+	assert.NoError(t, err)
+	assert.Equal(t, "echo", resp)
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{cmd}
+	})
+	defer s.Close()
+
+	p, err := NewTF2RconPool(s.Addr(), "pass", PoolConfig{IdleTimeout: 20 * time.Millisecond})
+	require.NoError(t, err)
+	defer p.Close()
+
+	assert.Equal(t, 1, p.Len())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, p.Len())
+
+	_, err = p.Query("echo")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.Len())
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{cmd}
+	})
+
+	p, err := NewTF2RconPool(s.Addr(), "pass", PoolConfig{HealthCheckInterval: 20 * time.Millisecond})
+	require.NoError(t, err)
+	defer p.Close()
+
+	s.Close()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, p.Len())
+
+	p.Close()
+	_, err = p.Query("echo")
+	assert.Equal(t, errPoolClosed, err)
+}
+
+func TestPoolHealthCheckStall(t *testing.T) {
+	var stall int32
+	stalled := make(chan struct{})
+	block := make(chan struct{})
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		if cmd == "echo" && atomic.CompareAndSwapInt32(&stall, 1, 0) {
+			close(stalled)
+			<-block
+		}
+		return []string{cmd}
+	})
+	defer s.Close()
+
+	p, err := NewTF2RconPool(s.Addr(), "pass", PoolConfig{MaxSize: 2, HealthCheckInterval: 20 * time.Millisecond})
+	require.NoError(t, err)
+	defer p.Close()
+	defer close(block)
+
+	// open a second connection
+	release := make(chan struct{})
+	go p.Do(context.Background(), func(c *TF2RconConnection) error {
+		<-release
+		return nil
+	})
+	_, err = p.Query("status")
+	require.NoError(t, err)
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, 2, p.Len())
+
+	atomic.StoreInt32(&stall, 1)
+	select {
+	case <-stalled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no health check")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := p.QueryContext(ctx, "status")
+	assert.NoError(t, err)
+	assert.Equal(t, "status", resp)
+}
+
+func TestPoolDeadIdle(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{cmd}
+	})
+	defer s.Close()
+
+	p, err := NewTF2RconPool(s.Addr(), "pass", PoolConfig{MaxSize: 1})
+	require.NoError(t, err)
+	defer p.Close()
+
+	// the server drops the idle connection
+	s.connsMu.Lock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.connsMu.Unlock()
+
+	pc := <-p.idle
+	for i := 0; pc.alive() && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.False(t, pc.alive())
+	p.idle <- pc
+
+	resp, err := p.Query("echo")
+	assert.NoError(t, err)
+	assert.Equal(t, "echo", resp)
+	assert.Equal(t, 1, p.Len())
+}
+
+func TestPoolCloseConcurrent(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{cmd}
+	})
+	defer s.Close()
+
+	p, err := NewTF2RconPool(s.Addr(), "pass", PoolConfig{MaxSize: 2})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Close()
+		}()
+	}
+	wg.Wait()
+
+	_, err = p.Query("echo")
+	assert.Equal(t, errPoolClosed, err)
+}
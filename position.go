@@ -0,0 +1,38 @@
+package TF2RconWrapper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Position is a point on the map, as logged in properties like
+// (attacker_position "-2310 -303 256")
+type Position struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	Z int `json:"z"`
+}
+
+// ParsePosition parses a position in the "X Y Z" form used by the logs
+func ParsePosition(s string) (Position, bool) {
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return Position{}, false
+	}
+
+	var coords [3]int
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return Position{}, false
+		}
+		coords[i] = n
+	}
+
+	return Position{coords[0], coords[1], coords[2]}, true
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d %d %d", p.X, p.Y, p.Z)
+}
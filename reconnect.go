@@ -0,0 +1,195 @@
+package TF2RconWrapper
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ConnState is the state of a TF2RconConnection's link to the server
+type ConnState int
+
+const (
+	// StateConnected means the connection is authenticated and usable
+	StateConnected ConnState = iota
+	// StateReconnecting means the connection was lost and is being redialed
+	StateReconnecting
+	// StateFailed means reconnection gave up; Reconnect can be called to
+	// try again
+	StateFailed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// ReconnectPolicy controls how a TF2RconConnection redials its server.
+// Attempts are spaced by a jittered delay starting at MinDelay and doubling
+// up to MaxDelay.
+type ReconnectPolicy struct {
+	MinDelay time.Duration
+	MaxDelay time.Duration
+
+	// MaxElapsed bounds automatic reconnection after the connection is
+	// lost. Zero retries until Close is called, a negative value disables
+	// automatic reconnection.
+	MaxElapsed time.Duration
+}
+
+// DefaultReconnectPolicy is the policy used by new connections
+var DefaultReconnectPolicy = ReconnectPolicy{
+	MinDelay:   500 * time.Millisecond,
+	MaxDelay:   30 * time.Second,
+	MaxElapsed: 5 * time.Minute,
+}
+
+var errConnectionClosed = errors.New("RCON connection closed")
+
+// backoff returns how long to wait after the given (zero based) failed
+// attempt: half of the exponential delay, plus up to as much again at random
+// so that many connections to one host don't redial in lockstep.
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	delay := p.MinDelay
+	for i := 0; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// SetReconnectPolicy changes how the connection is redialed
+func (c *TF2RconConnection) SetReconnectPolicy(p ReconnectPolicy) {
+	c.stateMu.Lock()
+	c.policy = p
+	c.stateMu.Unlock()
+}
+
+// OnStateChange sets a function called with the new state every time the
+// connection is lost, re-established or reconnection gives up. It is called
+// synchronously, so it shouldn't block.
+func (c *TF2RconConnection) OnStateChange(fn func(ConnState)) {
+	c.stateMu.Lock()
+	c.stateHandler = fn
+	c.stateMu.Unlock()
+}
+
+// State returns the current state of the connection
+func (c *TF2RconConnection) State() ConnState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state
+}
+
+func (c *TF2RconConnection) setState(state ConnState) {
+	c.stateMu.Lock()
+	changed := c.state != state
+	c.state = state
+	fn := c.stateHandler
+	c.stateMu.Unlock()
+
+	if changed && fn != nil {
+		fn(state)
+	}
+}
+
+func (c *TF2RconConnection) reconnectPolicy() ReconnectPolicy {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.policy
+}
+
+// connectionLost is called by d's read loop when its socket fails, and
+// starts redialing in the background if d is still the live connection
+func (c *TF2RconConnection) connectionLost(d *dispatcher, err error) {
+	c.rcLock.RLock()
+	current := c.rc == d
+	c.rcLock.RUnlock()
+
+	policy := c.reconnectPolicy()
+	if !current || c.isClosed() || policy.MaxElapsed < 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if policy.MaxElapsed > 0 {
+			ctx, cancel = context.WithTimeout(ctx, policy.MaxElapsed)
+		}
+		defer cancel()
+
+		c.ReconnectContext(ctx)
+	}()
+}
+
+// alive reports whether the connection to the server is up
+func (c *TF2RconConnection) alive() bool {
+	c.rcLock.RLock()
+	defer c.rcLock.RUnlock()
+	return c.rc != nil && c.rc.alive()
+}
+
+func (c *TF2RconConnection) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetLogSecret sets sv_logsecret on the server. The secret is set again
+// whenever the connection is re-established.
+func (c *TF2RconConnection) SetLogSecret(secret string) error {
+	return c.SetLogSecretContext(context.Background(), secret)
+}
+
+func (c *TF2RconConnection) SetLogSecretContext(ctx context.Context, secret string) error {
+	c.setupMu.Lock()
+	c.logSecret = secret
+	c.setupMu.Unlock()
+
+	_, err := c.QueryContext(ctx, "sv_logsecret "+secret)
+	return err
+}
+
+// replaySetup restores the log secret and log redirections on a freshly
+// dialed server connection
+func (c *TF2RconConnection) replaySetup(ctx context.Context) error {
+	c.setupMu.Lock()
+	secret := c.logSecret
+	addrs := make([]string, 0, len(c.logAddrs))
+	for addr := range c.logAddrs {
+		addrs = append(addrs, addr)
+	}
+	c.setupMu.Unlock()
+
+	if secret != "" {
+		if _, err := c.QueryContext(ctx, "sv_logsecret "+secret); err != nil {
+			return err
+		}
+	}
+
+	for _, addr := range addrs {
+		if _, err := c.QueryContext(ctx, "logaddress_add "+addr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package TF2RconWrapper
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// DefaultSecretLength is the number of digits of the log secrets the
+	// Listener generates when SetSecretLength hasn't been called
+	DefaultSecretLength = 18
+
+	// packets are only parsed with secrets of at least 6 characters, and the
+	// longest secrets still fit in an int64
+	minSecretLength = 6
+	maxSecretLength = 18
+)
+
+// SetSecretLength sets the number of digits of the log secrets generated for
+// sources added after the call, from 6 to 18. Longer secrets are harder to
+// guess, and so harder for a third party to send fake log lines to.
+func (l *Listener) SetSecretLength(n int) error {
+	if n < minSecretLength || n > maxSecretLength {
+		return fmt.Errorf("secret length %d not between %d and %d", n, minSecretLength, maxSecretLength)
+	}
+
+	l.mapMu.Lock()
+	l.secretLength = n
+	l.mapMu.Unlock()
+	return nil
+}
+
+// newSecret returns a random secret no source uses. l.mapMu must be held for
+// writing until the source is added, so no other source can take it.
+func (l *Listener) newSecret() string {
+	n := l.secretLength
+	if n == 0 {
+		n = DefaultSecretLength
+	}
+
+	for {
+		secret := randomSecret(n)
+		if _, ok := l.sources[secret]; !ok {
+			return secret
+		}
+	}
+}
+
+// randomSecret returns a random number of n digits, without leading zeros as
+// the server would drop them
+func randomSecret(n int) string {
+	min := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n-1)), nil)
+	max := new(big.Int).Mul(min, big.NewInt(9))
+
+	i, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		panic("reading random secret: " + err.Error())
+	}
+	return i.Add(i, min).String()
+}
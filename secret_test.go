@@ -0,0 +1,60 @@
+package TF2RconWrapper
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSecret(t *testing.T) {
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+
+	for _, n := range []int{0, 6, 12, 18} {
+		if n != 0 {
+			require.NoError(t, l.SetSecretLength(n))
+		}
+		expected := n
+		if expected == 0 {
+			expected = DefaultSecretLength
+		}
+
+		for i := 0; i < 100; i++ {
+			secret := l.newSecret()
+			assert.Len(t, secret, expected)
+			_, err := strconv.ParseInt(secret, 10, 64)
+			assert.NoError(t, err, secret)
+			assert.NotEqual(t, byte('0'), secret[0], secret)
+		}
+	}
+
+	assert.Error(t, l.SetSecretLength(5))
+	assert.Error(t, l.SetSecretLength(19))
+	assert.Equal(t, 18, l.secretLength)
+}
+
+func TestNewSecretUnique(t *testing.T) {
+	l := &Listener{mapMu: new(sync.RWMutex), sources: make(map[string]*Source)}
+	require.NoError(t, l.SetSecretLength(6))
+
+	// a secret taken between generating it and adding the source would be
+	// handed out twice
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				l.mapMu.Lock()
+				secret := l.newSecret()
+				l.sources[secret] = newSource(secret, nil, false, QueueConfig{})
+				l.mapMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, l.sources, 8000)
+}
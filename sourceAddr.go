@@ -0,0 +1,207 @@
+package TF2RconWrapper
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+)
+
+// RejectReason says why the Listener dropped a packet
+type RejectReason int
+
+const (
+	// RejectInvalid is a packet that isn't a log line
+	RejectInvalid RejectReason = iota
+	// RejectUnknownSecret is a packet whose secret belongs to no source
+	RejectUnknownSecret
+	// RejectWrongAddress is a packet for a source that came from an address
+	// other than its server's, or for a source whose server's address
+	// couldn't be looked up, see Source.ServerIPErr
+	RejectWrongAddress
+	// RejectUnknownAddress is a packet without a secret from an address no
+	// source was added for
+	RejectUnknownAddress
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectInvalid:
+		return "invalid packet"
+	case RejectUnknownSecret:
+		return "unknown secret"
+	case RejectWrongAddress:
+		return "wrong address"
+	case RejectUnknownAddress:
+		return "unknown address"
+	}
+	return "unknown reason"
+}
+
+// RejectedPacket describes a packet dropped by the Listener
+type RejectedPacket struct {
+	From   *net.UDPAddr
+	Reason RejectReason
+	Secret string  // empty for RejectInvalid and packets without a secret
+	Source *Source // the source the packet claimed to be for, if any
+}
+
+// SetRejectHook sets a function called with every packet the listener drops.
+// It's called from the goroutine reading packets, so it must not block.
+func (l *Listener) SetRejectHook(hook func(RejectedPacket)) {
+	l.mapMu.Lock()
+	l.rejectHook = hook
+	l.mapMu.Unlock()
+}
+
+// Rejected returns the number of packets the listener has dropped
+func (l *Listener) Rejected() uint64 {
+	return atomic.LoadUint64(&l.rejected)
+}
+
+func (l *Listener) reject(p RejectedPacket) {
+	atomic.AddUint64(&l.rejected, 1)
+	if p.Source != nil {
+		atomic.AddUint64(&p.Source.rejected, 1)
+	}
+
+	l.mapMu.RLock()
+	hook := l.rejectHook
+	l.mapMu.RUnlock()
+	if hook != nil {
+		hook(p)
+	}
+}
+
+var errAddrInUse = errors.New("a source already receives the logs of this address")
+
+// AddSourceAddr adds a Source receiving the log packets a server sends without
+// a secret, for servers whose sv_logsecret can't be set. addr is the "ip:port"
+// the server sends them from, usually its game port. Anyone able to send
+// packets from that address can write to the source, so prefer AddSource when
+// possible. If m isn't nil, the server's logs are redirected to the listener.
+func (l *Listener) AddSourceAddr(addr string, handler *EventListener, m *TF2RconConnection) (*Source, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// the key packets are looked up by
+	addr = udpAddr.String()
+
+	l.mapMu.Lock()
+	if _, ok := l.addrSources[addr]; ok {
+		l.mapMu.Unlock()
+		return nil, fmt.Errorf("%s: %w", addr, errAddrInUse)
+	}
+	s := newSource("", handler, false, l.queueConfig)
+	s.Addr = addr
+	s.rcon = m
+	s.serverIPs = []net.IP{udpAddr.IP}
+	l.configureSource(s)
+	if l.dropIfClosing(s) {
+		l.mapMu.Unlock()
+		return s, nil
+	}
+	l.addrSources[addr] = s
+	l.startDelivery(s)
+	l.mapMu.Unlock()
+
+	if m != nil {
+		m.RedirectLogs(l.redirectAddr)
+	}
+	return s, nil
+}
+
+// handleUnsecreted queues the log line in a packet sent without a secret for
+// the source added for its sender
+func (l *Listener) handleUnsecreted(packet []byte, from *net.UDPAddr) {
+	Lpos, err := getUnsecretedLine(packet)
+	if err != nil {
+		l.reject(RejectedPacket{From: from, Reason: RejectInvalid})
+		return
+	}
+
+	if l.print {
+		log.Println(string(packet[:len(packet)-1]))
+	}
+
+	l.mapMu.RLock()
+	source, ok := l.addrSources[from.String()]
+	l.mapMu.RUnlock()
+
+	if !ok {
+		l.reject(RejectedPacket{From: from, Reason: RejectUnknownAddress})
+		return
+	}
+	source.enqueue(packet[Lpos : len(packet)-1])
+}
+
+// SetServerIP makes s accept log packets only from ips, replacing the
+// addresses looked up from its rcon connection's host. With no ips, packets
+// are accepted from anywhere.
+func (s *Source) SetServerIP(ips ...net.IP) {
+	s.addrMu.Lock()
+	s.serverIPs, s.lookupErr = ips, nil
+	s.addrMu.Unlock()
+}
+
+// ServerIPErr returns why the address of s's rcon connection's host couldn't
+// be looked up, in which case s rejects every packet until SetServerIP is
+// called
+func (s *Source) ServerIPErr() error {
+	s.addrMu.RLock()
+	defer s.addrMu.RUnlock()
+	return s.lookupErr
+}
+
+// ServerIPs returns the addresses s accepts log packets from, or nil if it
+// accepts them from anywhere or ServerIPErr is set
+func (s *Source) ServerIPs() []net.IP {
+	s.addrMu.RLock()
+	defer s.addrMu.RUnlock()
+	return append([]net.IP(nil), s.serverIPs...)
+}
+
+// Rejected returns the number of packets with s's secret dropped because they
+// came from an address other than its server's
+func (s *Source) Rejected() uint64 {
+	return atomic.LoadUint64(&s.rejected)
+}
+
+// accepts reports whether s accepts log packets from ip
+func (s *Source) accepts(ip net.IP) bool {
+	s.addrMu.RLock()
+	defer s.addrMu.RUnlock()
+
+	if s.lookupErr != nil {
+		return false
+	}
+	if len(s.serverIPs) == 0 {
+		return true
+	}
+	for _, serverIP := range s.serverIPs {
+		if serverIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupServerIPs returns the addresses of m's server
+func lookupServerIPs(m *TF2RconConnection) ([]net.IP, error) {
+	host, _, err := net.SplitHostPort(m.host)
+	if err != nil {
+		host = m.host
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return ips, nil
+}
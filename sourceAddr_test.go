@@ -0,0 +1,187 @@
+package TF2RconWrapper
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceServerIP(t *testing.T) {
+	l, err := NewListenerAddr("0", "127.0.0.1:1234", false)
+	require.NoError(t, err)
+	defer l.Close()
+
+	rejected := make(chan RejectedPacket, 10)
+	l.SetRejectHook(func(p RejectedPacket) { rejected <- p })
+
+	said := make(chan string, 10)
+	l.mapMu.Lock()
+	s := newSource("1234567", &EventListener{
+		PlayerGlobalMessage: func(_ PlayerData, text string) { said <- text },
+	}, false, QueueConfig{})
+	s.SetServerIP(net.ParseIP("10.0.0.1"))
+	l.sources[s.Secret] = s
+	l.startDelivery(s)
+	l.mapMu.Unlock()
+
+	nextRejected := func() RejectedPacket {
+		select {
+		case p := <-rejected:
+			return p
+		case <-time.After(5 * time.Second):
+			t.Fatal("packet wasn't rejected")
+			return RejectedPacket{}
+		}
+	}
+
+	sendLines(t, l, "1234567", chatLine("spoofed"))
+	p := nextRejected()
+	assert.Equal(t, RejectWrongAddress, p.Reason)
+	assert.Equal(t, "1234567", p.Secret)
+	assert.Equal(t, s, p.Source)
+	assert.True(t, p.From.IP.IsLoopback())
+
+	sendLines(t, l, "7654321", chatLine("unknown"))
+	p = nextRejected()
+	assert.Equal(t, RejectUnknownSecret, p.Reason)
+	assert.Equal(t, "7654321", p.Secret)
+	assert.Nil(t, p.Source)
+
+	s.SetServerIP(net.ParseIP("10.0.0.1"), net.ParseIP("127.0.0.1"))
+	sendLines(t, l, "1234567", chatLine("hi"))
+	select {
+	case text := <-said:
+		assert.Equal(t, "hi", text)
+	case <-time.After(5 * time.Second):
+		t.Fatal("line from the server wasn't delivered")
+	}
+
+	assert.Equal(t, uint64(1), s.Rejected())
+	assert.Equal(t, uint64(2), l.Rejected())
+	assert.Empty(t, said)
+}
+
+func TestLookupServerIPs(t *testing.T) {
+	ips, err := lookupServerIPs(&TF2RconConnection{host: "127.0.0.1:27015"})
+	require.NoError(t, err)
+	require.Len(t, ips, 1)
+	assert.Equal(t, "127.0.0.1", ips[0].String())
+
+	ips, err = lookupServerIPs(&TF2RconConnection{host: "[::1]:27015"})
+	require.NoError(t, err)
+	require.Len(t, ips, 1)
+	assert.Equal(t, "::1", ips[0].String())
+
+	_, err = lookupServerIPs(&TF2RconConnection{host: "tf2.invalid:27015"})
+	assert.Error(t, err)
+
+	s := newSource("1234", nil, false, QueueConfig{})
+	assert.True(t, s.accepts(net.ParseIP("10.0.0.1")))
+	s.SetServerIP(net.ParseIP("127.0.0.1"))
+	assert.True(t, s.accepts(net.ParseIP("::ffff:127.0.0.1")))
+	assert.False(t, s.accepts(net.ParseIP("10.0.0.1")))
+	s.SetServerIP()
+	assert.Empty(t, s.ServerIPs())
+	assert.True(t, s.accepts(net.ParseIP("10.0.0.1")))
+
+	// nothing is accepted from a server that couldn't be looked up
+	s.lookupErr = err
+	assert.False(t, s.accepts(net.ParseIP("10.0.0.1")))
+	assert.Equal(t, err, s.ServerIPErr())
+	s.SetServerIP()
+	assert.Nil(t, s.ServerIPErr())
+	assert.True(t, s.accepts(net.ParseIP("10.0.0.1")))
+}
+
+func TestSourceAddr(t *testing.T) {
+	l, err := NewListenerAddr("0", "127.0.0.1:1234", false)
+	require.NoError(t, err)
+	defer l.Close()
+
+	rejected := make(chan RejectedPacket, 10)
+	l.SetRejectHook(func(p RejectedPacket) { rejected <- p })
+
+	// the server, and another host sending packets without a secret
+	port := l.conn.LocalAddr().(*net.UDPAddr).Port
+	dial := func() *net.UDPConn {
+		conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		require.NoError(t, err)
+		return conn
+	}
+	server, other := dial(), dial()
+	defer server.Close()
+	defer other.Close()
+	send := func(conn *net.UDPConn, line []byte) {
+		packet := append([]byte("\xff\xff\xff\xffR"), line...)
+		_, err := conn.Write(append(packet, 0))
+		require.NoError(t, err)
+	}
+
+	said := make(chan string, 10)
+	s, err := l.AddSourceAddr(server.LocalAddr().String(), &EventListener{
+		PlayerGlobalMessage: func(_ PlayerData, text string) { said <- text },
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, server.LocalAddr().String(), s.Addr)
+	assert.Empty(t, s.Secret)
+
+	_, err = l.AddSourceAddr(server.LocalAddr().String(), nil, nil)
+	assert.Error(t, err)
+
+	send(other, chatLine("spoofed"))
+	select {
+	case p := <-rejected:
+		assert.Equal(t, RejectUnknownAddress, p.Reason)
+		assert.Equal(t, other.LocalAddr().String(), p.From.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet wasn't rejected")
+	}
+
+	send(server, chatLine("hi"))
+	select {
+	case text := <-said:
+		assert.Equal(t, "hi", text)
+	case <-time.After(5 * time.Second):
+		t.Fatal("line from the server wasn't delivered")
+	}
+	assert.Empty(t, said)
+	assert.Contains(t, s.Logs().String(), `say "hi"`)
+
+	l.RemoveSource(s, nil)
+	l.mapMu.RLock()
+	assert.Empty(t, l.addrSources)
+	l.mapMu.RUnlock()
+}
+
+func TestSourceAddrLogName(t *testing.T) {
+	s := newSource("", nil, false, QueueConfig{})
+	s.Addr = "127.0.0.1:27015"
+	assert.Equal(t, "127.0.0.1-27015", s.logName())
+	s.Addr = "[::1]:27015"
+	assert.Equal(t, "--1-27015", s.logName())
+}
+
+func TestListenerClosingSource(t *testing.T) {
+	l, err := NewListenerAddr("0", "127.0.0.1:1234", false)
+	require.NoError(t, err)
+	require.NoError(t, l.SetLogRetention(LogRetention{SpoolDir: t.TempDir()}))
+	require.NoError(t, l.Close())
+
+	// sources added while closing are stopped right away
+	a := l.AddSource(nil, &TF2RconConnection{host: "127.0.0.1:27015"})
+	b, err := l.AddSourceAddr("127.0.0.1:27015", nil, nil)
+	require.NoError(t, err)
+	for _, s := range []*Source{a, b} {
+		select {
+		case _, ok := <-s.Subscribe(nil, SubscriptionConfig{}):
+			assert.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("subscription wasn't closed")
+		}
+		assert.Nil(t, s.logs.spool)
+		assert.NotEmpty(t, s.SpoolPath())
+	}
+}
@@ -0,0 +1,214 @@
+package TF2RconWrapper
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultLogMemory is the number of bytes of log lines a Source keeps in
+// memory when LogRetention.MaxMemory is 0
+const DefaultLogMemory = 1 << 20
+
+// LogRetention controls how much of its server's log a Source keeps
+type LogRetention struct {
+	// MaxMemory is the most bytes of log lines kept in memory, or
+	// DefaultLogMemory if it's 0. The oldest lines are dropped to stay under
+	// it, see Source.LogsTruncated. A negative MaxMemory keeps the whole log
+	// in memory for the source's lifetime; SpoolDir keeps it on disk instead.
+	MaxMemory int
+	// SpoolDir, if set, is a directory the source writes a file to holding
+	// every line it receives, however long the log gets. The file is kept
+	// until Source.RemoveSpool is called.
+	SpoolDir string
+}
+
+var errSpoolDirChanged = errors.New("the spool directory can't change while spooling")
+
+// logStore keeps the most recent lines of a log in memory, and optionally
+// every line on disk
+type logStore struct {
+	mu    sync.RWMutex
+	lines [][]byte // oldest first
+	size  int      // total length of lines
+	max   int      // negative for no limit
+
+	truncated bool // whether lines have been dropped
+
+	spool     *os.File // nil once the source is removed
+	spoolName string
+	spoolErr  error // the first error writing to spool
+}
+
+func newLogStore() *logStore {
+	return &logStore{max: DefaultLogMemory}
+}
+
+func (ls *logStore) setRetention(r LogRetention) error {
+	if r.MaxMemory == 0 {
+		r.MaxMemory = DefaultLogMemory
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if r.SpoolDir != "" && ls.spoolName != "" && filepath.Dir(ls.spoolName) != filepath.Clean(r.SpoolDir) {
+		return errSpoolDirChanged
+	}
+
+	ls.max = r.MaxMemory
+	ls.trim()
+
+	switch {
+	case r.SpoolDir == "" && ls.spoolName != "":
+		// nothing would refer to the file any more
+		return ls.removeSpool()
+	case r.SpoolDir != "" && ls.spoolName == "":
+		f, err := os.CreateTemp(r.SpoolDir, "tf2-log-*.log")
+		if err != nil {
+			return err
+		}
+		ls.spool, ls.spoolName, ls.spoolErr = f, f.Name(), nil
+	}
+	return nil
+}
+
+// write stores a line. It's copied, as lines usually share the rest of
+// their packet's buffer.
+func (ls *logStore) write(line []byte) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.lines = append(ls.lines, append([]byte(nil), line...))
+	ls.size += len(line)
+	ls.trim()
+
+	if ls.spool != nil && ls.spoolErr == nil {
+		_, ls.spoolErr = ls.spool.Write(line)
+	}
+}
+
+// trim drops the oldest lines until they fit in max. ls.mu must be held.
+func (ls *logStore) trim() {
+	if ls.max < 0 {
+		return
+	}
+
+	i := 0
+	for ls.size > ls.max && i < len(ls.lines) {
+		ls.size -= len(ls.lines[i])
+		ls.lines[i] = nil
+		i++
+	}
+	if i > 0 {
+		ls.truncated = true
+	}
+	ls.lines = ls.lines[i:]
+}
+
+func (ls *logStore) snapshot() []byte {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	buf := make([]byte, 0, ls.size)
+	for _, line := range ls.lines {
+		buf = append(buf, line...)
+	}
+	return buf
+}
+
+func (ls *logStore) reader() (io.ReadCloser, error) {
+	ls.mu.RLock()
+	name, err := ls.spoolName, ls.spoolErr
+	ls.mu.RUnlock()
+
+	if name == "" {
+		return io.NopCloser(bytes.NewReader(ls.snapshot())), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+func (ls *logStore) isTruncated() bool {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	return ls.truncated
+}
+
+func (ls *logStore) spoolPath() string {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	return ls.spoolName
+}
+
+// removeSpool stops spooling and deletes the spool file. ls.mu must be held.
+func (ls *logStore) removeSpool() error {
+	if ls.spool != nil {
+		ls.spool.Close()
+	}
+	name := ls.spoolName
+	ls.spool, ls.spoolName, ls.spoolErr = nil, "", nil
+
+	if name == "" {
+		return nil
+	}
+	return os.Remove(name)
+}
+
+// close stops spooling; the spool file is left on disk
+func (ls *logStore) close() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.spool != nil {
+		ls.spool.Close()
+		ls.spool = nil
+	}
+}
+
+// SetLogRetention changes how much of its server's log s keeps. Lines kept in
+// memory beyond the new limit are dropped. Spooling starts with the next
+// line received; stopping it deletes the spool file. SpoolDir can't be
+// changed while spooling, stop first to spool to another directory.
+func (s *Source) SetLogRetention(r LogRetention) error {
+	return s.logs.setRetention(r)
+}
+
+// Logs returns the log lines s has kept in memory, each ending with a
+// newline. It doesn't remove them from s. Check LogsTruncated to know
+// whether that's the whole log.
+func (s *Source) Logs() *bytes.Buffer {
+	return bytes.NewBuffer(s.logs.snapshot())
+}
+
+// LogsTruncated reports whether lines have been dropped from the start of
+// the log kept in memory to stay under LogRetention.MaxMemory
+func (s *Source) LogsTruncated() bool {
+	return s.logs.isTruncated()
+}
+
+// LogReader returns a reader of s's whole log if it is spooled to disk, and
+// of the lines kept in memory otherwise. A spooled log is read up to what has
+// been received when the reader reaches it.
+func (s *Source) LogReader() (io.ReadCloser, error) {
+	return s.logs.reader()
+}
+
+// SpoolPath returns the path of the file s's log is spooled to, or "" if it
+// isn't
+func (s *Source) SpoolPath() string {
+	return s.logs.spoolPath()
+}
+
+// RemoveSpool stops spooling s's log and deletes the spool file, once it's
+// no longer needed. LogReader then reads the lines kept in memory.
+func (s *Source) RemoveSpool() error {
+	s.logs.mu.Lock()
+	defer s.logs.mu.Unlock()
+	return s.logs.removeSpool()
+}
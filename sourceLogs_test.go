@@ -0,0 +1,151 @@
+package TF2RconWrapper
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceLogsRing(t *testing.T) {
+	s := newSource("1234", nil, false, QueueConfig{})
+	line := chatLine("0") // every line has the same length
+	require.NoError(t, s.SetLogRetention(LogRetention{MaxMemory: 3 * len(line)}))
+
+	for i := 0; i < 10; i++ {
+		s.logs.write(chatLine(fmt.Sprint(i)))
+	}
+
+	expected := string(chatLine("7")) + string(chatLine("8")) + string(chatLine("9"))
+	assert.Equal(t, expected, s.Logs().String())
+	assert.True(t, s.LogsTruncated())
+	// reading the logs doesn't remove them
+	assert.Equal(t, expected, s.Logs().String())
+
+	r, err := s.LogReader()
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(b))
+	assert.Equal(t, "", s.SpoolPath())
+
+	// shrinking the limit drops the oldest lines straight away
+	require.NoError(t, s.SetLogRetention(LogRetention{MaxMemory: len(line)}))
+	assert.Equal(t, string(chatLine("9")), s.Logs().String())
+}
+
+func TestSourceLogsDefault(t *testing.T) {
+	s := newSource("1234", nil, false, QueueConfig{})
+
+	// by default only the end of a long log is kept
+	line := chatLine("0")
+	for s.logs.size+len(line) <= DefaultLogMemory {
+		s.logs.write(line)
+	}
+	assert.False(t, s.LogsTruncated())
+	s.logs.write(chatLine("last"))
+	assert.True(t, s.LogsTruncated())
+	assert.True(t, s.Logs().Len() <= DefaultLogMemory)
+	assert.True(t, strings.HasSuffix(s.Logs().String(), string(chatLine("last"))))
+
+	// unless asked to keep all of it
+	s = newSource("1234", nil, false, QueueConfig{})
+	require.NoError(t, s.SetLogRetention(LogRetention{MaxMemory: -1}))
+	var all strings.Builder
+	for all.Len() < 4<<20 {
+		line := chatLine(fmt.Sprint(all.Len()))
+		all.Write(line)
+		s.logs.write(line)
+	}
+	assert.Equal(t, all.String(), s.Logs().String())
+	assert.False(t, s.LogsTruncated())
+}
+
+func TestSourceLogsSpool(t *testing.T) {
+	dir := t.TempDir()
+	s := newSource("1234", nil, false, QueueConfig{})
+	require.NoError(t, s.SetLogRetention(LogRetention{MaxMemory: 1, SpoolDir: dir}))
+	require.True(t, strings.HasPrefix(s.SpoolPath(), dir))
+
+	var all strings.Builder
+	for i := 0; i < 100; i++ {
+		line := chatLine(fmt.Sprint(i))
+		all.Write(line)
+		s.logs.write(line)
+	}
+
+	// no line fits in memory, the spool holds everything
+	assert.Equal(t, "", s.Logs().String())
+	r, err := s.LogReader()
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, all.String(), string(b))
+
+	// the spool outlives the source
+	s.logs.close()
+	r, err = s.LogReader()
+	require.NoError(t, err)
+	b, err = io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, all.String(), string(b))
+
+	path := s.SpoolPath()
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+
+	// until it's removed
+	require.NoError(t, s.RemoveSpool())
+	assert.Equal(t, "", s.SpoolPath())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSourceLogsSpoolDir(t *testing.T) {
+	dir := t.TempDir()
+	s := newSource("1234", nil, false, QueueConfig{})
+	require.NoError(t, s.SetLogRetention(LogRetention{SpoolDir: dir}))
+	path := s.SpoolPath()
+
+	// the log spooled so far would be lost by moving to another directory
+	assert.Error(t, s.SetLogRetention(LogRetention{SpoolDir: t.TempDir()}))
+	assert.Equal(t, path, s.SpoolPath())
+	require.NoError(t, s.SetLogRetention(LogRetention{SpoolDir: dir + "/"}))
+	assert.Equal(t, path, s.SpoolPath())
+
+	// stopping spooling deletes the file
+	require.NoError(t, s.SetLogRetention(LogRetention{}))
+	assert.Equal(t, "", s.SpoolPath())
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSourceLogsConcurrent(t *testing.T) {
+	s := newSource("1234", nil, false, QueueConfig{})
+	require.NoError(t, s.SetLogRetention(LogRetention{MaxMemory: 1000}))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			s.logs.write(chatLine(fmt.Sprint(i)))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			logs := s.Logs().String()
+			assert.True(t, len(logs) <= 1000)
+			assert.True(t, logs == "" || strings.HasSuffix(logs, "\"\n"), logs)
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,173 @@
+package TF2RconWrapper
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DefaultQueueSize is the number of log lines a Source buffers when
+// QueueConfig.Size is 0
+const DefaultQueueSize = 1024
+
+// OverflowPolicy decides what happens to a log line that arrives while its
+// Source's queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest drops the line that just arrived
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued line to make room
+	OverflowDropOldest
+	// OverflowBlock waits for room. This stops the Listener reading packets,
+	// so one slow handler holds up every source.
+	OverflowBlock
+)
+
+// QueueConfig controls the queue of log lines each Source's handler is fed
+// from. Lines from one server are handled one at a time in the order they
+// arrived; different servers are handled in parallel.
+type QueueConfig struct {
+	Size     int
+	Overflow OverflowPolicy
+}
+
+// SetQueueConfig sets the queue configuration of sources added after the
+// call
+func (l *Listener) SetQueueConfig(config QueueConfig) {
+	l.mapMu.Lock()
+	l.queueConfig = config
+	l.mapMu.Unlock()
+}
+
+// SetLogRetention sets the log retention of sources added after the call. It
+// fails if r.SpoolDir is set but isn't a directory.
+func (l *Listener) SetLogRetention(r LogRetention) error {
+	if r.SpoolDir != "" {
+		if err := checkDir(r.SpoolDir); err != nil {
+			return err
+		}
+	}
+
+	l.mapMu.Lock()
+	l.logRetention = r
+	l.mapMu.Unlock()
+	return nil
+}
+
+// SetLogFiles sets the log file configuration of sources added after the
+// call. It fails if config.Dir is set but isn't a directory.
+func (l *Listener) SetLogFiles(config LogFileConfig) error {
+	if config.Dir != "" {
+		if err := checkDir(config.Dir); err != nil {
+			return err
+		}
+	}
+
+	l.mapMu.Lock()
+	l.logFiles = config
+	l.mapMu.Unlock()
+	return nil
+}
+
+// SetLocation sets the time zone the log timestamps of sources added after
+// the call are read in, see Source.SetLocation
+func (l *Listener) SetLocation(loc *time.Location) {
+	l.mapMu.Lock()
+	l.location = loc
+	l.mapMu.Unlock()
+}
+
+// Dropped returns the number of log lines dropped because the source's queue
+// was full
+func (s *Source) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// enqueue queues a log line, with its trailing newline, for s's handler
+func (s *Source) enqueue(line []byte) {
+	switch s.overflow {
+	case OverflowBlock:
+		select {
+		case s.queue <- line:
+		case <-s.done:
+		}
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case s.queue <- line:
+				return
+			default:
+			}
+
+			select {
+			case <-s.queue:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case s.queue <- line:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// startDelivery starts s's delivery goroutine. l.mapMu must be held so
+// Shutdown can't miss it.
+func (l *Listener) startDelivery(s *Source) {
+	l.workers.Add(1)
+	go func() {
+		defer l.workers.Done()
+		l.deliver(s)
+	}()
+}
+
+// deliver feeds the lines queued for s to its handler until s is removed, or
+// until its queue is closed and empty
+func (l *Listener) deliver(s *Source) {
+	defer s.shutdown()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case line, ok := <-s.queue:
+			if !ok || atomic.LoadInt32(s.closed) == 1 {
+				return
+			}
+			l.process(s, line)
+		}
+	}
+}
+
+// shutdown releases what s holds once it's removed and no more lines will
+// be delivered
+func (s *Source) shutdown() {
+	s.flush()
+	s.closeSubscriptions()
+	s.closeLogFile()
+	s.logs.close()
+}
+
+// dropIfClosing stops s, a new source, if the listener is closing, as it
+// would never see a line. l.mapMu must be held.
+func (l *Listener) dropIfClosing(s *Source) bool {
+	if !l.closing {
+		return false
+	}
+	s.stop()
+	s.shutdown()
+	return true
+}
+
+// stop ends s's delivery goroutine
+func (s *Source) stop() {
+	s.stopOnce.Do(func() {
+		atomic.StoreInt32(s.closed, 1)
+		close(s.done)
+	})
+}
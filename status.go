@@ -0,0 +1,164 @@
+package TF2RconWrapper
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerStatus is the parsed output of the status command
+type ServerStatus struct {
+	Hostname string
+	Version  string
+	Address  string // udp/ip, "ip:port"
+	PublicIP string
+	SteamID  string // the server's own SteamID
+	Map      string
+	Tags     []string
+
+	SourceTVPort  int // zero if SourceTV isn't running
+	SourceTVDelay time.Duration
+
+	Humans     int
+	Bots       int
+	MaxPlayers int
+
+	// Players has every client on the server, including bots and
+	// players that are still connecting
+	Players []Player
+}
+
+var (
+	// #      3 "Sk1LL0"   [U:1:198288660]   01:23   55   0 active 1.2.3.4:27005
+	// #      2 "SourceTV" BOT                                 active
+	reStatusPlayer = regexp.MustCompile(`^#\s*(\d+)\s+"(.*)"\s+(\S+)\s+(?:(\d+(?::\d+){1,2})\s+(\d+)\s+(\d+)\s+)?(\w+)(?:\s+(\S+))?\s*$`)
+	// 13 humans, 1 bots (25 max)
+	reStatusPlayers = regexp.MustCompile(`^(\d+) humans, (\d+) bots \((\d+) max\)`)
+	// 14 (25 max), on servers that don't count bots separately
+	reStatusPlayersOld = regexp.MustCompile(`^(\d+) \((\d+) max\)`)
+	// port 27020, delay 90.0s
+	reStatusSourceTV = regexp.MustCompile(`port (\d+), delay ([\d.]+)s`)
+	// 192.168.1.2:27015  (public ip: 1.2.3.4)
+	reStatusPublicIP = regexp.MustCompile(`\(public ip: ([^)]+)\)`)
+)
+
+// GetStatus runs the status command and parses all of its output
+func (c *TF2RconConnection) GetStatus() (*ServerStatus, error) {
+	return c.GetStatusContext(context.Background())
+}
+
+// GetStatusContext is GetStatus, bounded by ctx
+func (c *TF2RconConnection) GetStatusContext(ctx context.Context) (*ServerStatus, error) {
+	resp, err := c.QueryContext(ctx, "status")
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseStatus(resp), nil
+}
+
+// ParseStatus parses the output of the status command. Lines it doesn't
+// recognise are ignored.
+func ParseStatus(status string) *ServerStatus {
+	s := new(ServerStatus)
+
+	for _, line := range strings.Split(status, "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "#") {
+			if player, ok := parseStatusPlayer(line); ok {
+				s.Players = append(s.Players, player)
+			}
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if i == -1 {
+			continue
+		}
+		key := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "hostname":
+			s.Hostname = value
+		case "version":
+			s.Version = value
+		case "udp/ip":
+			s.Address = firstField(value)
+			if m := reStatusPublicIP.FindStringSubmatch(value); m != nil {
+				s.PublicIP = m[1]
+			}
+		case "steamid":
+			s.SteamID = firstField(value)
+		case "map":
+			s.Map = firstField(value)
+		case "tags":
+			if value != "" {
+				s.Tags = strings.Split(value, ",")
+			}
+		case "sourcetv":
+			if m := reStatusSourceTV.FindStringSubmatch(value); m != nil {
+				s.SourceTVPort, _ = strconv.Atoi(m[1])
+				delay, _ := strconv.ParseFloat(m[2], 64)
+				s.SourceTVDelay = time.Duration(delay * float64(time.Second))
+			}
+		case "players":
+			if m := reStatusPlayers.FindStringSubmatch(value); m != nil {
+				s.Humans, _ = strconv.Atoi(m[1])
+				s.Bots, _ = strconv.Atoi(m[2])
+				s.MaxPlayers, _ = strconv.Atoi(m[3])
+			} else if m := reStatusPlayersOld.FindStringSubmatch(value); m != nil {
+				s.Humans, _ = strconv.Atoi(m[1])
+				s.MaxPlayers, _ = strconv.Atoi(m[2])
+			}
+		}
+	}
+
+	return s
+}
+
+func parseStatusPlayer(line string) (Player, bool) {
+	m := reStatusPlayer.FindStringSubmatch(line)
+	if m == nil {
+		return Player{}, false
+	}
+
+	p := Player{
+		UserID:    m[1],
+		Username:  m[2],
+		Connected: parseConnected(m[4]),
+		State:     m[7],
+		Ip:        m[8],
+	}
+	p.SteamID, _ = ParseSteamID(m[3])
+	p.Ping, _ = strconv.Atoi(m[5])
+	p.Loss, _ = strconv.Atoi(m[6])
+
+	return p, true
+}
+
+// parseConnected parses the connected column, either "mm:ss" or "h:mm:ss"
+func parseConnected(str string) time.Duration {
+	var d time.Duration
+	if str == "" {
+		return d
+	}
+
+	for _, part := range strings.Split(str, ":") {
+		n, _ := strconv.Atoi(part)
+		d = d*60 + time.Duration(n)
+	}
+
+	return d * time.Second
+}
+
+func firstField(str string) string {
+	fields := strings.Fields(str)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
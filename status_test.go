@@ -0,0 +1,88 @@
+package TF2RconWrapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statusOutput = `hostname: TF2Stadium | Server #1
+version : 3358291/24 3358291 secure
+udp/ip  : 192.168.1.2:27015  (public ip: 1.2.3.4)
+steamid : [A:1:1234567:8012] (90099999999999999)
+account : not logged in  (No account specified)
+map     : cp_badlands at: 0 x, 0 y, 0 z
+tags    : cp,increased_maxplayers
+sourcetv:  port 27020, delay 90.0s
+players : 3 humans, 1 bots (25 max)
+edicts  : 642 used of 2048 max
+# userid name                uniqueid            connected ping loss state  adr
+#      2 "SourceTV"          BOT                                     active
+#      3 "Sk1LL0"            [U:1:198288660]     01:23       55    0 active 10.0.0.1:27005
+#      4 "name "with" quotes" [U:1:40572775]     1:02:03     70    2 spawning 10.0.0.2:27005
+#      5 "Tedstur"           [U:1:98355052]      00:05      200   10 connecting 10.0.0.3:27005
+`
+
+func TestParseStatus(t *testing.T) {
+	s := ParseStatus(statusOutput)
+
+	assert.Equal(t, "TF2Stadium | Server #1", s.Hostname)
+	assert.Equal(t, "3358291/24 3358291 secure", s.Version)
+	assert.Equal(t, "192.168.1.2:27015", s.Address)
+	assert.Equal(t, "1.2.3.4", s.PublicIP)
+	assert.Equal(t, "[A:1:1234567:8012]", s.SteamID)
+	assert.Equal(t, "cp_badlands", s.Map)
+	assert.Equal(t, []string{"cp", "increased_maxplayers"}, s.Tags)
+	assert.Equal(t, 27020, s.SourceTVPort)
+	assert.Equal(t, 90*time.Second, s.SourceTVDelay)
+	assert.Equal(t, 3, s.Humans)
+	assert.Equal(t, 1, s.Bots)
+	assert.Equal(t, 25, s.MaxPlayers)
+
+	require.Len(t, s.Players, 4)
+	assert.Equal(t, Player{
+		UserID:   "2",
+		Username: "SourceTV",
+		SteamID:  mustParseSteamID("BOT"),
+		State:    "active",
+	}, s.Players[0])
+	assert.Equal(t, Player{
+		UserID:    "3",
+		Username:  "Sk1LL0",
+		SteamID:   mustParseSteamID("[U:1:198288660]"),
+		Connected: 83 * time.Second,
+		Ping:      55,
+		State:     "active",
+		Ip:        "10.0.0.1:27005",
+	}, s.Players[1])
+	assert.Equal(t, `name "with" quotes`, s.Players[2].Username)
+	assert.Equal(t, time.Hour+2*time.Minute+3*time.Second, s.Players[2].Connected)
+	assert.Equal(t, 2, s.Players[2].Loss)
+	assert.Equal(t, "spawning", s.Players[2].State)
+	assert.Equal(t, "connecting", s.Players[3].State)
+	assert.Equal(t, 200, s.Players[3].Ping)
+}
+
+func TestGetPlayers(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{statusOutput}
+	})
+	defer s.Close()
+
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+
+	players, err := c.GetPlayers()
+	require.NoError(t, err)
+	require.Len(t, players, 3)
+	assert.Equal(t, "[U:1:198288660]", players[0].SteamID.String())
+	assert.Equal(t, "10.0.0.1:27005", players[0].Ip)
+	assert.Equal(t, 55, players[0].Ping)
+
+	status, err := c.GetStatus()
+	require.NoError(t, err)
+	assert.Len(t, status.Players, 4)
+}
@@ -0,0 +1,131 @@
+package TF2RconWrapper
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidSteamID is returned when a string isn't a SteamID in any of the
+// formats ParseSteamID knows
+var ErrInvalidSteamID = errors.New("invalid SteamID")
+
+// SteamIDKind tells what a SteamID identifies
+type SteamIDKind uint8
+
+const (
+	// SteamIDNone is the kind of the zero SteamID
+	SteamIDNone SteamIDKind = iota
+	// SteamIDIndividual is a player's Steam account
+	SteamIDIndividual
+	// SteamIDBot is any bot, logged as "BOT"
+	SteamIDBot
+	// SteamIDConsole is the server console, logged as "Console"
+	SteamIDConsole
+)
+
+// steamID64Base is the SteamID64 of account 0 in the public universe
+const steamID64Base = 76561197960265728
+
+// SteamID identifies a player. AccountID is only set for individuals.
+type SteamID struct {
+	Kind      SteamIDKind
+	AccountID uint32
+}
+
+// ParseSteamID parses a SteamID3 ("[U:1:N]"), a legacy SteamID
+// ("STEAM_0:Y:Z"), a SteamID64, "BOT" or "Console"
+func ParseSteamID(s string) (SteamID, error) {
+	switch {
+	case s == "BOT":
+		return SteamID{Kind: SteamIDBot}, nil
+	case s == "Console":
+		return SteamID{Kind: SteamIDConsole}, nil
+
+	case strings.HasPrefix(s, "[U:1:") && strings.HasSuffix(s, "]"):
+		account, err := strconv.ParseUint(s[len("[U:1:"):len(s)-1], 10, 32)
+		if err != nil {
+			return SteamID{}, ErrInvalidSteamID
+		}
+		return SteamID{SteamIDIndividual, uint32(account)}, nil
+
+	case strings.HasPrefix(s, "STEAM_0:") || strings.HasPrefix(s, "STEAM_1:"):
+		parts := strings.Split(s[len("STEAM_0:"):], ":")
+		if len(parts) != 2 || (parts[0] != "0" && parts[0] != "1") {
+			return SteamID{}, ErrInvalidSteamID
+		}
+		z, err := strconv.ParseUint(parts[1], 10, 31)
+		if err != nil {
+			return SteamID{}, ErrInvalidSteamID
+		}
+		y := uint64(parts[0][0] - '0')
+		return SteamID{SteamIDIndividual, uint32(z<<1 | y)}, nil
+	}
+
+	id64, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return SteamID{}, ErrInvalidSteamID
+	}
+	return SteamIDFrom64(id64)
+}
+
+// SteamIDFrom64 returns the SteamID of an individual account's SteamID64
+func SteamIDFrom64(id64 uint64) (SteamID, error) {
+	if id64 < steamID64Base || id64-steamID64Base > 1<<32-1 {
+		return SteamID{}, ErrInvalidSteamID
+	}
+	return SteamID{SteamIDIndividual, uint32(id64 - steamID64Base)}, nil
+}
+
+// String returns id as the logs show it: "[U:1:N]", "BOT" or "Console".
+// The zero SteamID is the empty string.
+func (id SteamID) String() string {
+	switch id.Kind {
+	case SteamIDIndividual:
+		return "[U:1:" + strconv.FormatUint(uint64(id.AccountID), 10) + "]"
+	case SteamIDBot:
+		return "BOT"
+	case SteamIDConsole:
+		return "Console"
+	}
+	return ""
+}
+
+// ID64 returns the SteamID64 of an individual, and 0 for anyone else
+func (id SteamID) ID64() uint64 {
+	if id.Kind != SteamIDIndividual {
+		return 0
+	}
+	return steamID64Base + uint64(id.AccountID)
+}
+
+// Legacy returns id in the STEAM_0:Y:Z form SourceMod uses. Bots and the
+// console are "BOT" and "Console" as in String.
+func (id SteamID) Legacy() string {
+	if id.Kind != SteamIDIndividual {
+		return id.String()
+	}
+	return "STEAM_0:" + strconv.Itoa(int(id.AccountID&1)) + ":" +
+		strconv.FormatUint(uint64(id.AccountID>>1), 10)
+}
+
+// MarshalText encodes id as String does, so SteamIDs are JSON strings
+func (id SteamID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText accepts anything ParseSteamID does, and the empty string for
+// the zero SteamID
+func (id *SteamID) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*id = SteamID{}
+		return nil
+	}
+
+	parsed, err := ParseSteamID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
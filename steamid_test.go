@@ -0,0 +1,116 @@
+package TF2RconWrapper
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseSteamID(s string) SteamID {
+	id, err := ParseSteamID(s)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func TestSteamIDConversions(t *testing.T) {
+	for _, c := range []struct {
+		id3    string
+		id64   uint64
+		legacy string
+	}{
+		{"[U:1:0]", 76561197960265728, "STEAM_0:0:0"},
+		{"[U:1:1]", 76561197960265729, "STEAM_0:1:0"},
+		{"[U:1:2]", 76561197960265730, "STEAM_0:0:1"},
+		{"[U:1:198288660]", 76561198158554388, "STEAM_0:0:99144330"},
+		{"[U:1:56108026]", 76561198016373754, "STEAM_0:0:28054013"},
+		{"[U:1:33573908]", 76561197993839636, "STEAM_0:0:16786954"},
+		{"[U:1:45115290]", 76561198005381018, "STEAM_0:0:22557645"},
+		{"[U:1:56973094]", 76561198017238822, "STEAM_0:0:28486547"},
+		{"[U:1:10403006]", 76561197970668734, "STEAM_0:0:5201503"},
+		{"[U:1:76213301]", 76561198036479029, "STEAM_0:1:38106650"},
+		{"[U:1:4294967295]", 76561202255233023, "STEAM_0:1:2147483647"},
+	} {
+		id, err := ParseSteamID(c.id3)
+		require.NoError(t, err, c.id3)
+		assert.Equal(t, SteamIDIndividual, id.Kind)
+		assert.Equal(t, c.id3, id.String())
+		assert.Equal(t, c.id64, id.ID64(), c.id3)
+		assert.Equal(t, c.legacy, id.Legacy(), c.id3)
+
+		// every form parses back to the same SteamID
+		for _, s := range []string{c.legacy, "STEAM_1" + c.legacy[len("STEAM_0"):], strconv.FormatUint(c.id64, 10)} {
+			parsed, err := ParseSteamID(s)
+			require.NoError(t, err, s)
+			assert.Equal(t, id, parsed, s)
+		}
+		parsed, err := SteamIDFrom64(c.id64)
+		require.NoError(t, err)
+		assert.Equal(t, id, parsed)
+	}
+}
+
+func TestSteamIDSpecial(t *testing.T) {
+	bot := mustParseSteamID("BOT")
+	assert.Equal(t, SteamID{Kind: SteamIDBot}, bot)
+	assert.Equal(t, "BOT", bot.String())
+	assert.Equal(t, "BOT", bot.Legacy())
+	assert.Equal(t, uint64(0), bot.ID64())
+
+	console := mustParseSteamID("Console")
+	assert.Equal(t, SteamID{Kind: SteamIDConsole}, console)
+	assert.Equal(t, "Console", console.String())
+	assert.Equal(t, "Console", console.Legacy())
+	assert.Equal(t, uint64(0), console.ID64())
+
+	assert.Equal(t, "", SteamID{}.String())
+	assert.Equal(t, uint64(0), SteamID{}.ID64())
+}
+
+func TestSteamIDInvalid(t *testing.T) {
+	for _, s := range []string{
+		"", "bot", "STEAM_ID_PENDING", "[U:1:]", "[U:1:-1]", "[U:1:4294967296]",
+		"[U:0:12]", "[A:1:1234567:8012]", "[U:1:12", "STEAM_0:2:5", "STEAM_0:0",
+		"STEAM_0:0:2147483648", "STEAM_2:0:5", "76561197960265727", "76561202255233024",
+		"+76561197960265728", "12abc",
+	} {
+		_, err := ParseSteamID(s)
+		assert.Equal(t, ErrInvalidSteamID, err, s)
+	}
+}
+
+func TestSteamIDJSON(t *testing.T) {
+	type row struct {
+		ID      SteamID   `json:"id"`
+		Missing SteamID   `json:"missing"`
+		Bots    []SteamID `json:"bots"`
+	}
+
+	in := row{
+		ID:   mustParseSteamID("[U:1:198288660]"),
+		Bots: []SteamID{mustParseSteamID("BOT"), mustParseSteamID("Console")},
+	}
+	b, err := json.Marshal(in)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"[U:1:198288660]","missing":"","bots":["BOT","Console"]}`, string(b))
+
+	var out row
+	require.NoError(t, json.Unmarshal(b, &out))
+	assert.Equal(t, in, out)
+
+	// other formats are accepted when decoding
+	require.NoError(t, json.Unmarshal([]byte(`{"id":"76561198158554388"}`), &out))
+	assert.Equal(t, in.ID, out.ID)
+	require.NoError(t, json.Unmarshal([]byte(`{"id":"STEAM_0:0:99144330"}`), &out))
+	assert.Equal(t, in.ID, out.ID)
+	assert.Error(t, json.Unmarshal([]byte(`{"id":"nobody"}`), &out))
+
+	// players keep marshalling the steamid as before
+	b, err = json.Marshal(PlayerData{Username: "Sk1LL0", UserId: "2", SteamId: in.ID, Team: "Red"})
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"steamid":"[U:1:198288660]"`)
+}
@@ -0,0 +1,138 @@
+package TF2RconWrapper
+
+import "sync"
+
+// DefaultSubscriptionBuffer is the channel buffer of a subscription when
+// SubscriptionConfig.Buffer is 0
+const DefaultSubscriptionBuffer = 64
+
+// SubscriptionConfig controls a channel returned by Source.Subscribe. When
+// the channel is full, Overflow decides whether events are dropped or the
+// source waits for the subscriber. Waiting holds up the source's handler and
+// every other subscriber of the source, but not other sources.
+type SubscriptionConfig struct {
+	Buffer   int
+	Overflow OverflowPolicy
+}
+
+type subscription struct {
+	ch       chan Event
+	filter   func(Event) bool
+	overflow OverflowPolicy
+
+	mu     sync.Mutex // held while sending, protects closed
+	closed bool
+	done   chan struct{} // closed to abandon a blocked send
+}
+
+// Subscribe returns a channel receiving the events of s's server for which
+// filter returns true, or all of them if filter is nil, in the order they
+// arrived. Events are sent after s's EventListener has handled them. The
+// channel is closed by Unsubscribe, or when s is removed.
+func (s *Source) Subscribe(filter func(Event) bool, config SubscriptionConfig) <-chan Event {
+	if config.Buffer <= 0 {
+		config.Buffer = DefaultSubscriptionBuffer
+	}
+
+	sub := &subscription{
+		ch:       make(chan Event, config.Buffer),
+		filter:   filter,
+		overflow: config.Overflow,
+		done:     make(chan struct{}),
+	}
+
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	if s.subs == nil {
+		// the source was removed
+		close(sub.ch)
+		return sub.ch
+	}
+	s.subs[sub.ch] = sub
+	return sub.ch
+}
+
+// Unsubscribe stops sending events to ch, a channel returned by Subscribe,
+// and closes it
+func (s *Source) Unsubscribe(ch <-chan Event) {
+	s.subsMu.Lock()
+	sub, ok := s.subs[ch]
+	delete(s.subs, ch)
+	s.subsMu.Unlock()
+
+	if ok {
+		sub.close()
+	}
+}
+
+// publish sends e to the subscriptions wanting it
+func (s *Source) publish(e Event) {
+	s.subsMu.Lock()
+	subs := make([]*subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		if sub.filter == nil || sub.filter(e) {
+			subs = append(subs, sub)
+		}
+	}
+	s.subsMu.Unlock()
+
+	for _, sub := range subs {
+		sub.send(e)
+	}
+}
+
+// closeSubscriptions closes every subscription when s is removed
+func (s *Source) closeSubscriptions() {
+	s.subsMu.Lock()
+	subs := s.subs
+	s.subs = nil
+	s.subsMu.Unlock()
+
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+func (sub *subscription) send(e Event) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+
+	switch sub.overflow {
+	case OverflowBlock:
+		select {
+		case sub.ch <- e:
+		case <-sub.done:
+		}
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case sub.ch <- e:
+				return
+			default:
+			}
+
+			select {
+			case <-sub.ch:
+			default:
+			}
+		}
+
+	default:
+		select {
+		case sub.ch <- e:
+		default:
+		}
+	}
+}
+
+func (sub *subscription) close() {
+	close(sub.done) // lets a blocked send return
+	sub.mu.Lock()
+	sub.closed = true
+	close(sub.ch)
+	sub.mu.Unlock()
+}
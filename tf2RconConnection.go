@@ -1,12 +1,12 @@
 package TF2RconWrapper
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/TF2Stadium/rcon"
@@ -15,18 +15,33 @@ import (
 // TF2RconConnection represents a rcon connection to a TF2 server
 type TF2RconConnection struct {
 	rcLock sync.RWMutex
-	rc     *rcon.RemoteConsole
-
-	host         string
-	password     string
-	reconnecting *int32
+	rc     *dispatcher
+
+	host      string
+	password  string
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// stateMu protects state, stateHandler, policy and reconnectDone
+	stateMu       sync.Mutex
+	state         ConnState
+	stateHandler  func(ConnState)
+	policy        ReconnectPolicy
+	reconnectDone chan struct{} // closed when the running reconnection ends
+
+	// server setup restored after reconnecting
+	setupMu   sync.Mutex
+	logSecret string
+	logAddrs  map[string]struct{}
 }
 
+// defaultQueryTimeout is how long a query waits for the server when the
+// caller's context carries no deadline
+const defaultQueryTimeout = 5 * time.Second
+
 var (
 	ErrUnknownCommand = errors.New("Unknown Command")
 	CVarValueRegex    = regexp.MustCompile(`^"(?:.*?)" = "(.*?)"`)
-	//# userid name                uniqueid            connected ping loss state  adr
-	rePlayerInfo = regexp.MustCompile(`^#\s+(\d+)\s+"(.+)"\s+(\[U:1:\d+\])\s+\d+:\d+\s+\d+\s+\d+\s+\w+\s+(\d+\.+\d+\.\d+\.\d+:\d+)`)
 )
 
 type UnknownCommand string
@@ -36,6 +51,23 @@ func (c UnknownCommand) Error() string {
 }
 
 func (c *TF2RconConnection) QueryNoResp(req string) error {
+	return c.QueryNoRespContext(context.Background(), req)
+}
+
+// QueryNoRespContext sends a query without waiting for the server's response.
+// If the server stops reading, it gives up when the context is done (after 5
+// seconds when it has no deadline) and the connection is closed.
+func (c *TF2RconConnection) QueryNoRespContext(ctx context.Context, req string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultQueryTimeout)
+		defer cancel()
+	}
+
 	c.rcLock.RLock()
 	defer c.rcLock.RUnlock()
 
@@ -43,48 +75,54 @@ func (c *TF2RconConnection) QueryNoResp(req string) error {
 		return errors.New("RCON connection is nil")
 	}
 
-	_, err := c.rc.Write(req)
-	return err
+	return c.rc.write(ctx, req)
 }
 
 // Query executes a query and returns the server responses
 func (c *TF2RconConnection) Query(req string) (string, error) {
-	c.rcLock.RLock()
-	defer c.rcLock.RUnlock()
+	return c.QueryContext(context.Background(), req)
+}
 
-	if c.rc == nil {
-		return "", errors.New("RCON connection is nil")
+// QueryContext executes a query and returns the server responses. It waits
+// until the context is done (5 seconds when it has no deadline) and returns
+// ctx.Err() as soon as the context is cancelled. Queries from several
+// goroutines are pipelined over the same connection.
+func (c *TF2RconConnection) QueryContext(ctx context.Context, req string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
 	}
 
-	reqID, reqErr := c.rc.Write(req)
-	if reqErr != nil {
-		// log.Println(reqErr)
-		return "", reqErr
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultQueryTimeout)
+		defer cancel()
 	}
 
-	resp, respID, respErr := c.rc.Read(5 * time.Second)
-	if respErr != nil {
-		// log.Println(respErr)
-		return "", respErr
+	c.rcLock.RLock()
+	rc := c.rc
+	c.rcLock.RUnlock()
+
+	if rc == nil {
+		return "", errors.New("RCON connection is nil")
 	}
 
-	counter := 10
-	// retry 10 times
-	for {
-		if reqID == respID {
-			break
-		} else if counter < 0 {
-			return "", errors.New("Couldn't get a response.")
-		} else {
-			counter--
-			resp, respID, respErr = c.rc.Read(5 * time.Second)
-			if respErr != nil {
-				// log.Println(respErr)
-				return "", reqErr
-			}
+	p, err := rc.send(ctx, req)
+	if err != nil {
+		// log.Println(err)
+		return "", err
+	}
+
+	select {
+	case <-ctx.Done():
+		rc.forget(p)
+		return "", ctx.Err()
+	case err := <-p.done:
+		if err != nil {
+			return "", err
 		}
 	}
 
+	resp := p.resp.String()
 	if strings.HasPrefix(resp, "Unknown command") {
 		return resp, UnknownCommand(req)
 	}
@@ -93,7 +131,11 @@ func (c *TF2RconConnection) Query(req string) (string, error) {
 }
 
 func (c *TF2RconConnection) GetConVar(cvar string) (string, error) {
-	raw, err := c.Query(cvar)
+	return c.GetConVarContext(context.Background(), cvar)
+}
+
+func (c *TF2RconConnection) GetConVarContext(ctx context.Context, cvar string) (string, error) {
+	raw, err := c.QueryContext(ctx, cvar)
 
 	if err != nil {
 		return "", err
@@ -116,12 +158,22 @@ func (c *TF2RconConnection) GetConVar(cvar string) (string, error) {
 }
 
 func (c *TF2RconConnection) SetConVar(cvar string, val string) (string, error) {
-	return c.Query(fmt.Sprintf("%s \"%s\"", cvar, val))
+	return c.SetConVarContext(context.Background(), cvar, val)
+}
+
+func (c *TF2RconConnection) SetConVarContext(ctx context.Context, cvar string, val string) (string, error) {
+	return c.QueryContext(ctx, fmt.Sprintf("%s \"%s\"", cvar, val))
 }
 
-// GetPlayers returns a list of players in the server. Includes bots.
+// GetPlayers returns a list of players in the server connected with a
+// Steam account. GetStatus also lists bots.
 func (c *TF2RconConnection) GetPlayers() ([]Player, error) {
-	statusString, err := c.Query("status")
+	return c.GetPlayersContext(context.Background())
+}
+
+// GetPlayersContext is GetPlayers, bounded by ctx
+func (c *TF2RconConnection) GetPlayersContext(ctx context.Context) ([]Player, error) {
+	statusString, err := c.QueryContext(ctx, "status")
 	if err != nil {
 		return nil, err
 	}
@@ -129,7 +181,10 @@ func (c *TF2RconConnection) GetPlayers() ([]Player, error) {
 	index := strings.Index(statusString, "#")
 	i := 0
 	for index == -1 {
-		statusString, _ = c.Query("status")
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		statusString, _ = c.QueryContext(ctx, "status")
 		index = strings.Index(statusString, "#")
 		i++
 		if i == 5 {
@@ -140,16 +195,10 @@ func (c *TF2RconConnection) GetPlayers() ([]Player, error) {
 	users := strings.Split(statusString[index:], "\n")
 	var list []Player
 	for _, userString := range users {
-		if !rePlayerInfo.MatchString(userString) {
+		player, ok := parseStatusPlayer(strings.TrimSpace(userString))
+		if !ok || player.SteamID.Kind != SteamIDIndividual || player.Ip == "" {
 			continue
 		}
-		matches := rePlayerInfo.FindStringSubmatch(userString)
-		player := Player{
-			UserID:   matches[1],
-			Username: matches[2],
-			SteamID:  matches[3],
-			Ip:       matches[4],
-		}
 		list = append(list, player)
 	}
 
@@ -158,52 +207,84 @@ func (c *TF2RconConnection) GetPlayers() ([]Player, error) {
 
 // KickPlayer kicks a player
 func (c *TF2RconConnection) KickPlayer(p Player, message string) error {
-	return c.KickPlayerID(p.UserID, message)
+	return c.KickPlayerContext(context.Background(), p, message)
+}
+
+func (c *TF2RconConnection) KickPlayerContext(ctx context.Context, p Player, message string) error {
+	return c.KickPlayerIDContext(ctx, p.UserID, message)
 }
 
 // Kicks a player with the given player ID
 func (c *TF2RconConnection) KickPlayerID(userID string, message string) error {
+	return c.KickPlayerIDContext(context.Background(), userID, message)
+}
+
+func (c *TF2RconConnection) KickPlayerIDContext(ctx context.Context, userID string, message string) error {
 	query := fmt.Sprintf("kickid %s %s", userID, message)
-	_, err := c.Query(query)
+	_, err := c.QueryContext(ctx, query)
 	return err
 }
 
 // BanPlayer bans a player
 func (c *TF2RconConnection) BanPlayer(minutes int, p Player, message string) error {
+	return c.BanPlayerContext(context.Background(), minutes, p, message)
+}
+
+func (c *TF2RconConnection) BanPlayerContext(ctx context.Context, minutes int, p Player, message string) error {
 	query := "banid " + fmt.Sprintf("%v", minutes) + " " + p.UserID
 	if message != "" {
 		query += " \"" + message + "\""
 	}
-	_, err := c.Query(query)
+	_, err := c.QueryContext(ctx, query)
 	return err
 }
 
 // UnbanPlayer unbans a player
 func (c *TF2RconConnection) UnbanPlayer(p Player) error {
+	return c.UnbanPlayerContext(context.Background(), p)
+}
+
+func (c *TF2RconConnection) UnbanPlayerContext(ctx context.Context, p Player) error {
 	query := "unbanid " + p.UserID
-	_, err := c.Query(query)
+	_, err := c.QueryContext(ctx, query)
 	return err
 }
 
 // Say sends a message to the TF2 server chat
 func (c *TF2RconConnection) Say(message string) error {
+	return c.SayContext(context.Background(), message)
+}
+
+func (c *TF2RconConnection) SayContext(ctx context.Context, message string) error {
 	query := "say " + message
-	_, err := c.Query(query)
+	_, err := c.QueryContext(ctx, query)
 	return err
 }
 
 func (c *TF2RconConnection) Sayf(format string, a ...interface{}) error {
-	err := c.Say(fmt.Sprintf(format, a...))
-	return err
+	return c.SayfContext(context.Background(), format, a...)
+}
+
+func (c *TF2RconConnection) SayfContext(ctx context.Context, format string, a ...interface{}) error {
+	return c.SayContext(ctx, fmt.Sprintf(format, a...))
 }
 
 // ChangeRconPassword changes the rcon password and updates the current connection
 // to use the new password
 func (c *TF2RconConnection) ChangeRconPassword(password string) error {
-	_, err := c.SetConVar("rcon_password", password)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	return c.ChangeRconPasswordContext(ctx, password)
+}
+
+// ChangeRconPasswordContext is ChangeRconPassword, with ctx bounding both the
+// query and the reconnection that follows it
+func (c *TF2RconConnection) ChangeRconPasswordContext(ctx context.Context, password string) error {
+	_, err := c.SetConVarContext(ctx, "rcon_password", password)
 
 	if err == nil {
-		err = c.Reconnect(1 * time.Minute)
+		err = c.ReconnectContext(ctx)
 	}
 
 	return err
@@ -211,8 +292,12 @@ func (c *TF2RconConnection) ChangeRconPassword(password string) error {
 
 // ChangeMap changes the map
 func (c *TF2RconConnection) ChangeMap(mapname string) error {
+	return c.ChangeMapContext(context.Background(), mapname)
+}
+
+func (c *TF2RconConnection) ChangeMapContext(ctx context.Context, mapname string) error {
 	query := "changelevel \"" + mapname + "\""
-	res, err := c.Query(query)
+	res, err := c.QueryContext(ctx, query)
 	if res != "" {
 		return errors.New("Map not found.")
 	}
@@ -221,17 +306,29 @@ func (c *TF2RconConnection) ChangeMap(mapname string) error {
 
 // ChangeServerPassword changes the server password
 func (c *TF2RconConnection) ChangeServerPassword(password string) error {
-	_, err := c.SetConVar("sv_password", password)
+	return c.ChangeServerPasswordContext(context.Background(), password)
+}
+
+func (c *TF2RconConnection) ChangeServerPasswordContext(ctx context.Context, password string) error {
+	_, err := c.SetConVarContext(ctx, "sv_password", password)
 	return err
 }
 
 // GetServerPassword returns the server password
 func (c *TF2RconConnection) GetServerPassword() (string, error) {
-	return c.GetConVar("sv_password")
+	return c.GetServerPasswordContext(context.Background())
+}
+
+func (c *TF2RconConnection) GetServerPasswordContext(ctx context.Context) (string, error) {
+	return c.GetConVarContext(ctx, "sv_password")
 }
 
 func (c *TF2RconConnection) AddTag(newTag string) error {
-	tags, err := c.GetConVar("sv_tags")
+	return c.AddTagContext(context.Background(), newTag)
+}
+
+func (c *TF2RconConnection) AddTagContext(ctx context.Context, newTag string) error {
+	tags, err := c.GetConVarContext(ctx, "sv_tags")
 	if err != nil {
 		return err
 	}
@@ -247,7 +344,7 @@ func (c *TF2RconConnection) AddTag(newTag string) error {
 
 	if !tagExists {
 		newTags := strings.Join([]string{tags, newTag}, ",")
-		_, err := c.SetConVar("sv_tags", newTags)
+		_, err := c.SetConVarContext(ctx, "sv_tags", newTags)
 		return err
 	}
 
@@ -255,7 +352,11 @@ func (c *TF2RconConnection) AddTag(newTag string) error {
 }
 
 func (c *TF2RconConnection) RemoveTag(tagName string) error {
-	tags, err := c.GetConVar("sv_tags")
+	return c.RemoveTagContext(context.Background(), tagName)
+}
+
+func (c *TF2RconConnection) RemoveTagContext(ctx context.Context, tagName string) error {
+	tags, err := c.GetConVarContext(ctx, "sv_tags")
 	if err != nil {
 		return err
 	}
@@ -265,7 +366,7 @@ func (c *TF2RconConnection) RemoveTag(tagName string) error {
 		// duplicated or trailing commas in the sv_tags string; however
 		// Source servers clean up the value of sv_tags to remove those
 		// anyways
-		_, err := c.SetConVar("sv_tags", strings.Replace(tags, tagName, "", -1))
+		_, err := c.SetConVarContext(ctx, "sv_tags", strings.Replace(tags, tagName, "", -1))
 		return err
 	}
 
@@ -274,21 +375,40 @@ func (c *TF2RconConnection) RemoveTag(tagName string) error {
 
 // RedirectLogs send the logaddress_add command
 func (c *TF2RconConnection) RedirectLogs(addr string) error {
+	return c.RedirectLogsContext(context.Background(), addr)
+}
+
+func (c *TF2RconConnection) RedirectLogsContext(ctx context.Context, addr string) error {
+	c.setupMu.Lock()
+	c.logAddrs[addr] = struct{}{}
+	c.setupMu.Unlock()
+
 	query := "logaddress_add " + addr
-	_, err := c.Query(query)
+	_, err := c.QueryContext(ctx, query)
 	return err
 }
 
-func (c *TF2RconConnection) StopLogRedirection(addr string) {
+// StopLogRedirection sends the logaddress_del command
+func (c *TF2RconConnection) StopLogRedirection(addr string) error {
+	return c.StopLogRedirectionContext(context.Background(), addr)
+}
+
+func (c *TF2RconConnection) StopLogRedirectionContext(ctx context.Context, addr string) error {
+	c.setupMu.Lock()
+	delete(c.logAddrs, addr)
+	c.setupMu.Unlock()
+
 	query := fmt.Sprintf("logaddress_del %s", addr)
-	c.QueryNoResp(query)
+	return c.QueryNoRespContext(ctx, query)
 }
 
 // Close closes the connection
 func (c *TF2RconConnection) Close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+
 	c.rcLock.Lock()
 	if c.rc != nil {
-		c.rc.Close()
+		c.rc.close()
 	}
 	c.rcLock.Unlock()
 }
@@ -296,9 +416,15 @@ func (c *TF2RconConnection) Close() {
 // ExecConfig accepts a string and executes its lines one by one. Assumes
 // UNiX line endings
 func (c *TF2RconConnection) ExecConfig(config string) error {
+	return c.ExecConfigContext(context.Background(), config)
+}
+
+// ExecConfigContext is ExecConfig, stopping at the first line that fails or
+// once ctx is done
+func (c *TF2RconConnection) ExecConfigContext(ctx context.Context, config string) error {
 	lines := strings.Split(config, "\n")
 	for _, line := range lines {
-		_, err := c.Query(line)
+		_, err := c.QueryContext(ctx, line)
 		if err != nil {
 			return err
 		}
@@ -309,45 +435,163 @@ func (c *TF2RconConnection) ExecConfig(config string) error {
 // NewTF2RconConnection builds a new TF2RconConnection to a server at address ("ip:port") using
 // a rcon_password password
 func NewTF2RconConnection(address, password string) (*TF2RconConnection, error) {
+	return newTF2RconConnection(address, password, DefaultReconnectPolicy)
+}
+
+func newTF2RconConnection(address, password string, policy ReconnectPolicy) (*TF2RconConnection, error) {
 	rc, err := rcon.Dial(address, password)
 	if err != nil {
 		return nil, err
 	}
 
-	return &TF2RconConnection{
-		rc:           rc,
-		host:         address,
-		password:     password,
-		reconnecting: new(int32),
-	}, nil
+	c := &TF2RconConnection{
+		host:     address,
+		password: password,
+		closed:   make(chan struct{}),
+		policy:   policy,
+		logAddrs: make(map[string]struct{}),
+	}
+
+	c.rcLock.Lock()
+	c.rc = newDispatcher(rc, c.connectionLost)
+	c.rcLock.Unlock()
+
+	return c, nil
 }
 
+// Reconnect closes the current connection and keeps dialing the server until
+// it succeeds or duration passes
 func (c *TF2RconConnection) Reconnect(duration time.Duration) error {
-	if atomic.LoadInt32(c.reconnecting) == 1 {
-		c.rcLock.RLock()
-		c.rcLock.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	return c.ReconnectContext(ctx)
+}
+
+// ReconnectContext closes the current connection and keeps dialing the server,
+// backing off between attempts, until it succeeds or ctx is done. The log
+// secret and log redirections are then set up again; if that fails the server
+// is dialed again, so the connection is only reported connected once the
+// setup is restored. If a reconnection is already in progress, it waits for
+// that one instead. Queries made while reconnecting fail immediately.
+func (c *TF2RconConnection) ReconnectContext(ctx context.Context) error {
+	c.stateMu.Lock()
+	if wait := c.reconnectDone; wait != nil {
+		c.stateMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+
+		if c.State() != StateConnected {
+			return errors.New("Couldn't reconnect")
+		}
 		return nil
 	}
 
-	c.rcLock.Lock()
-	defer c.rcLock.Unlock()
+	done := make(chan struct{})
+	c.reconnectDone = done
+	c.stateMu.Unlock()
+
+	defer func() {
+		c.stateMu.Lock()
+		c.reconnectDone = nil
+		c.stateMu.Unlock()
+		close(done)
+	}()
+
+	c.setState(StateReconnecting)
+	policy := c.reconnectPolicy()
+	for attempt := 0; ; attempt++ {
+		if err := c.redial(ctx); err != nil {
+			c.setState(StateFailed)
+			return err
+		}
+
+		err := c.replaySetup(ctx)
+		if err == nil {
+			c.setState(StateConnected)
+			return nil
+		}
 
-	atomic.StoreInt32(c.reconnecting, 1)
-	defer atomic.StoreInt32(c.reconnecting, 0)
+		// the server's logs wouldn't reach us, dial again and retry
+		select {
+		case <-time.After(policy.backoff(attempt)):
+			continue
+		case <-ctx.Done():
+		case <-c.closed:
+		}
+		c.setState(StateFailed)
+		return err
+	}
+}
 
+func (c *TF2RconConnection) redial(ctx context.Context) error {
+	c.rcLock.Lock()
 	if c.rc != nil {
-		c.rc.Close()
+		c.rc.close()
+		c.rc = nil
 	}
+	c.rcLock.Unlock()
 
-	now := time.Now()
+	policy := c.reconnectPolicy()
 	var err error
 
-	for time.Since(now) <= duration {
-		c.rc, err = rcon.Dial(c.host, c.password)
+	for attempt := 0; ctx.Err() == nil; attempt++ {
+		var rc *rcon.RemoteConsole
+		rc, err = dialContext(ctx, c.host, c.password)
 		if err == nil {
+			c.rcLock.Lock()
+			defer c.rcLock.Unlock()
+
+			if c.isClosed() {
+				rc.Close()
+				return errConnectionClosed
+			}
+
+			c.rc = newDispatcher(rc, c.connectionLost)
 			return nil
 		}
+
+		select {
+		case <-ctx.Done():
+		case <-c.closed:
+			return errConnectionClosed
+		case <-time.After(policy.backoff(attempt)):
+		}
 	}
 
+	if err == nil {
+		err = ctx.Err()
+	}
 	return err
 }
+
+// dialContext is rcon.Dial, returning early with ctx's error once ctx is
+// done. rcon can't cancel a dial, so an abandoned one carries on in the
+// background and its connection is closed.
+func dialContext(ctx context.Context, host, password string) (*rcon.RemoteConsole, error) {
+	type result struct {
+		rc  *rcon.RemoteConsole
+		err error
+	}
+	dialed := make(chan result, 1)
+	go func() {
+		rc, err := rcon.Dial(host, password)
+		dialed <- result{rc, err}
+	}()
+
+	select {
+	case r := <-dialed:
+		return r.rc, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-dialed; r.rc != nil {
+				r.rc.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
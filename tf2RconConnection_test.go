@@ -0,0 +1,508 @@
+package TF2RconWrapper
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	serverdataAuth          = 3
+	serverdataAuthResponse  = 2
+	serverdataExecCommand   = 2
+	serverdataResponseValue = 0
+)
+
+// fakeServer speaks just enough of the Source RCON protocol to test
+// TF2RconConnection against. Like srcds it executes commands one at a time,
+// in order; handle returns the response bodies to send for a command, one
+// packet each. Empty commands get a single empty response.
+type fakeServer struct {
+	ln       net.Listener
+	password string
+	handle   func(cmd string) []string
+
+	connsMu sync.Mutex
+	conns   []net.Conn
+}
+
+func newFakeServer(t *testing.T, password string, handle func(cmd string) []string) *fakeServer {
+	return newFakeServerAddr(t, "127.0.0.1:0", password, handle)
+}
+
+func newFakeServerAddr(t *testing.T, addr, password string, handle func(cmd string) []string) *fakeServer {
+	ln, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+
+	s := &fakeServer{ln: ln, password: password, handle: handle}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeServer) Close() {
+	s.ln.Close()
+	s.connsMu.Lock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.connsMu.Unlock()
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.connsMu.Lock()
+		s.conns = append(s.conns, conn)
+		s.connsMu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *fakeServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		var size, id, typ int32
+		if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
+			return
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		binary.Read(bytes.NewReader(body[0:4]), binary.LittleEndian, &id)
+		binary.Read(bytes.NewReader(body[4:8]), binary.LittleEndian, &typ)
+		cmd := string(bytes.TrimRight(body[8:], "\x00"))
+
+		switch typ {
+		case serverdataAuth:
+			writePacket(conn, id, serverdataResponseValue, "")
+			if cmd != s.password {
+				id = -1
+			}
+			writePacket(conn, id, serverdataAuthResponse, "")
+		case serverdataExecCommand:
+			resps := []string{""}
+			if cmd != "" {
+				resps = s.handle(cmd)
+			}
+			for _, resp := range resps {
+				writePacket(conn, id, serverdataResponseValue, resp)
+			}
+		}
+	}
+}
+
+func writePacket(w io.Writer, id, typ int32, body string) error {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, int32(len(body)+10))
+	binary.Write(buf, binary.LittleEndian, id)
+	binary.Write(buf, binary.LittleEndian, typ)
+	buf.WriteString(body)
+	buf.Write([]byte{0, 0})
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func TestQueryContextCancel(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		if cmd == "hang" {
+			<-block
+			return nil
+		}
+		return []string{"ok " + cmd}
+	})
+	defer s.Close()
+
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+
+	resp, err := c.QueryContext(context.Background(), "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "ok echo", resp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = c.QueryContext(ctx, "hang")
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.QueryContext(ctx, "hang")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestQueryContextWriteBlocked(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	// the server stops reading once it gets "stall"
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		if cmd == "stall" {
+			<-block
+		}
+		return []string{""}
+	})
+	defer s.Close()
+
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetReconnectPolicy(ReconnectPolicy{MaxElapsed: -1})
+
+	require.NoError(t, c.QueryNoResp("stall"))
+
+	// fill the socket buffers until a write blocks
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			err := c.QueryNoRespContext(ctx, strings.Repeat("x", 1000))
+			cancel()
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("write to a server that stopped reading didn't give up")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.QueryContext(ctx, "echo")
+	assert.Error(t, err)
+}
+
+func TestReconnectContext(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{""}
+	})
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.ReconnectContext(context.Background()))
+
+	s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, c.ReconnectContext(ctx))
+}
+
+func TestReconnectContextDial(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{""}
+	})
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetReconnectPolicy(ReconnectPolicy{MaxElapsed: -1})
+	s.Close()
+
+	// a server that takes connections but never answers the password
+	ln, err := net.Listen("tcp", s.Addr())
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, c.ReconnectContext(ctx))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestReconnectReplayFails(t *testing.T) {
+	var mu sync.Mutex
+	refuse := 0
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(cmd, "logaddress_add") && refuse > 0 {
+			refuse--
+			return []string{"Unknown command \"logaddress_add\"\n"}
+		}
+		return []string{""}
+	})
+	defer s.Close()
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+	c.SetReconnectPolicy(ReconnectPolicy{MinDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond})
+
+	var states []ConnState
+	c.OnStateChange(func(state ConnState) { states = append(states, state) })
+	require.NoError(t, c.RedirectLogs("127.0.0.1:8080"))
+
+	// the connection isn't reported up until the logs are redirected again
+	mu.Lock()
+	refuse = 2
+	mu.Unlock()
+	require.NoError(t, c.ReconnectContext(context.Background()))
+	assert.Equal(t, []ConnState{StateReconnecting, StateConnected}, states)
+	mu.Lock()
+	assert.Equal(t, 0, refuse)
+	refuse = 1000
+	mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, c.ReconnectContext(ctx))
+	assert.Equal(t, StateFailed, c.State())
+}
+
+func TestQueryMultiPacket(t *testing.T) {
+	var cvarlist []string
+	for i := 0; i < 3; i++ {
+		cvarlist = append(cvarlist, strings.Repeat(strconv.Itoa(i), 4000))
+	}
+
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		switch cmd {
+		case "cvarlist":
+			return cvarlist
+		case "silent":
+			return nil
+		case "foo":
+			return []string{"Unknown command \"foo\"\n"}
+		}
+		return []string{cmd}
+	})
+	defer s.Close()
+
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+
+	resp, err := c.Query("cvarlist")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join(cvarlist, ""), resp)
+
+	resp, err = c.Query("silent")
+	require.NoError(t, err)
+	assert.Equal(t, "", resp)
+
+	// a response that was never read must not leak into the next query
+	require.NoError(t, c.QueryNoResp("stale"))
+	resp, err = c.Query("echo")
+	require.NoError(t, err)
+	assert.Equal(t, "echo", resp)
+
+	_, err = c.Query("foo")
+	assert.Equal(t, UnknownCommand("foo"), err)
+}
+
+func TestQueryConcurrent(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		// split every response to make interleaving more likely
+		return []string{cmd[:2], cmd[2:]}
+	})
+	defer s.Close()
+
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req := "echo " + strconv.Itoa(i)
+			resp, err := c.Query(req)
+			assert.NoError(t, err)
+			assert.Equal(t, req, resp)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestQueryConnectionLost(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{cmd}
+	})
+
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+
+	s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.QueryContext(ctx, "echo")
+	assert.Error(t, err)
+	assert.NotEqual(t, context.DeadlineExceeded, err)
+}
+
+func TestConnectionLostWhileClosing(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{cmd}
+	})
+
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	c.SetReconnectPolicy(ReconnectPolicy{MaxElapsed: -1})
+
+	// the socket fails just as Close or a reconnection takes the lock
+	c.rcLock.Lock()
+	d := c.rc
+	s.Close()
+	for d.alive() {
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		d.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing the connection waited for the read loop, which waited for the lock")
+	}
+	c.rcLock.Unlock()
+	c.Close()
+}
+
+func TestAutoReconnect(t *testing.T) {
+	cmds := make(chan string, 10)
+	handle := func(cmd string) []string {
+		cmds <- cmd
+		return []string{""}
+	}
+
+	s := newFakeServer(t, "pass", handle)
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+
+	states := make(chan ConnState, 10)
+	c.OnStateChange(func(state ConnState) { states <- state })
+	c.SetReconnectPolicy(ReconnectPolicy{MinDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+
+	require.NoError(t, c.SetLogSecret("1234"))
+	require.NoError(t, c.RedirectLogs("127.0.0.1:8080"))
+	assert.Equal(t, "sv_logsecret 1234", <-cmds)
+	assert.Equal(t, "logaddress_add 127.0.0.1:8080", <-cmds)
+
+	s.Close()
+	assert.Equal(t, StateReconnecting, <-states)
+
+	time.Sleep(100 * time.Millisecond)
+	s = newFakeServerAddr(t, s.Addr(), "pass", handle)
+	defer s.Close()
+
+	select {
+	case state := <-states:
+		assert.Equal(t, StateConnected, state)
+	case <-time.After(5 * time.Second):
+		t.Fatal("didn't reconnect")
+	}
+	assert.Equal(t, "sv_logsecret 1234", <-cmds)
+	assert.Equal(t, "logaddress_add 127.0.0.1:8080", <-cmds)
+
+	_, err = c.Query("echo")
+	assert.NoError(t, err)
+}
+
+func TestReconnectPolicyBackoff(t *testing.T) {
+	p := ReconnectPolicy{MinDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		delay := p.backoff(attempt)
+		assert.True(t, delay >= max/2 && delay <= max, attempt)
+	}
+}
+
+func TestCommandsContext(t *testing.T) {
+	cmds := make(chan string, 10)
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		cmds <- cmd
+		if cmd == "sv_tags" {
+			return []string{`"sv_tags" = "tf2stadium" ( def. "" )`}
+		}
+		return []string{""}
+	})
+	defer s.Close()
+
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx := context.Background()
+	require.NoError(t, c.SayfContext(ctx, "gl %s", "hf"))
+	assert.Equal(t, "say gl hf", <-cmds)
+	require.NoError(t, c.KickPlayerContext(ctx, Player{UserID: "2"}, "bye"))
+	assert.Equal(t, "kickid 2 bye", <-cmds)
+	require.NoError(t, c.AddTagContext(ctx, "lobby"))
+	assert.Equal(t, "sv_tags", <-cmds)
+	assert.Equal(t, `sv_tags "tf2stadium,lobby"`, <-cmds)
+
+	// a cancelled context sends nothing
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, c.BanPlayerContext(ctx, 5, Player{UserID: "2"}, ""))
+	assert.Equal(t, context.Canceled, c.ChangeServerPasswordContext(ctx, "pw"))
+	_, err = c.GetServerPasswordContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, c.RemoveTagContext(ctx, "lobby"))
+	assert.Equal(t, context.Canceled, c.StopLogRedirectionContext(ctx, "127.0.0.1:8080"))
+	assert.Empty(t, cmds)
+}
+
+func TestStopLogRedirectionError(t *testing.T) {
+	s := newFakeServer(t, "pass", func(cmd string) []string {
+		return []string{""}
+	})
+	defer s.Close()
+
+	c, err := NewTF2RconConnection(s.Addr(), "pass")
+	require.NoError(t, err)
+	require.NoError(t, c.StopLogRedirection("127.0.0.1:8080"))
+
+	c.Close()
+	assert.Error(t, c.StopLogRedirection("127.0.0.1:8080"))
+}
@@ -0,0 +1,276 @@
+package TF2RconWrapper
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Property is a (key "value") pair trailing a log message
+type Property struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Properties are the (key "value") pairs trailing a log message, in the order
+// they appear in. Servers and plugins can add any properties to any message.
+type Properties []Property
+
+// Get returns the value of the first property named key
+func (props Properties) Get(key string) (string, bool) {
+	for _, p := range props {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// Int returns the value of the first property named key as an integer
+func (props Properties) Int(key string) (int, bool) {
+	str, ok := props.Get(key)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(str)
+	return n, err == nil
+}
+
+// Duration returns the value of the first property named key, a number of
+// seconds, as a time.Duration
+func (props Properties) Duration(key string) (time.Duration, bool) {
+	str, ok := props.Get(key)
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(str, 64)
+	return time.Duration(seconds * float64(time.Second)), err == nil
+}
+
+// Position returns the value of the first property named key as a Position
+func (props Properties) Position(key string) (Position, bool) {
+	str, ok := props.Get(key)
+	if !ok {
+		return Position{}, false
+	}
+	return ParsePosition(str)
+}
+
+// logProperties copies props, which usually live in a scanning buffer, for
+// an event to keep
+func (props Properties) logProperties() LogProperties {
+	if len(props) == 0 {
+		return LogProperties{}
+	}
+	return LogProperties{append(Properties(nil), props...)}
+}
+
+// logScanner reads a log message from left to right. Messages look like
+//
+//	"name<uid><steamid><team>" verb ["name<uid><steamid><team>"] ... (key "value")...
+//
+// or start with World, Team "name", server_cvar: and the like. Everything
+// the scanner returns is a substring of the message, so scanning doesn't
+// allocate.
+type logScanner struct {
+	s   string
+	pos int
+}
+
+func (sc *logScanner) rest() string {
+	return sc.s[sc.pos:]
+}
+
+func (sc *logScanner) skipSpaces() {
+	for sc.pos < len(sc.s) && sc.s[sc.pos] == ' ' {
+		sc.pos++
+	}
+}
+
+// word returns the next run of non-space characters
+func (sc *logScanner) word() string {
+	sc.skipSpaces()
+	start := sc.pos
+	if i := strings.IndexByte(sc.s[start:], ' '); i != -1 {
+		sc.pos += i
+	} else {
+		sc.pos = len(sc.s)
+	}
+	return sc.s[start:sc.pos]
+}
+
+// expect consumes the next word if it is w
+func (sc *logScanner) expect(w string) bool {
+	save := sc.pos
+	if sc.word() == w {
+		return true
+	}
+	sc.pos = save
+	return false
+}
+
+// quoted returns the next "quoted" string. As values aren't escaped, the
+// string ends at the first quote followed by a space, a parenthesis, a colon
+// or the end of the message.
+func (sc *logScanner) quoted() (string, bool) {
+	sc.skipSpaces()
+	if sc.pos >= len(sc.s) || sc.s[sc.pos] != '"' {
+		return "", false
+	}
+
+	start := sc.pos + 1
+	for i := start; i < len(sc.s); i++ {
+		if sc.s[i] != '"' {
+			continue
+		}
+		if i+1 == len(sc.s) || sc.s[i+1] == ' ' || sc.s[i+1] == ')' || sc.s[i+1] == ':' {
+			sc.pos = i + 1
+			return sc.s[start:i], true
+		}
+	}
+
+	return "", false
+}
+
+func (sc *logScanner) quotedInt() (int, bool) {
+	str, ok := sc.quoted()
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(str)
+	return n, err == nil
+}
+
+// restQuoted returns everything between the next quote and the last quote of
+// the message, for free text like chat messages that may contain quotes
+func (sc *logScanner) restQuoted() (string, bool) {
+	sc.skipSpaces()
+	if sc.pos >= len(sc.s) || sc.s[sc.pos] != '"' {
+		return "", false
+	}
+
+	end := strings.LastIndexByte(sc.s, '"')
+	if end <= sc.pos {
+		return "", false
+	}
+
+	str := sc.s[sc.pos+1 : end]
+	sc.pos = end + 1
+	return str, true
+}
+
+// player parses a "name<uid><steamid><team>" tuple. Names can contain any
+// character, so the tuple ends at the first `>"` that closes a well formed
+// <uid><steamid><team> suffix.
+func (sc *logScanner) player() (PlayerData, bool) {
+	sc.skipSpaces()
+	if sc.pos >= len(sc.s) || sc.s[sc.pos] != '"' {
+		return PlayerData{}, false
+	}
+
+	start := sc.pos + 1
+	for i := start; i < len(sc.s); {
+		j := strings.Index(sc.s[i:], `>"`)
+		if j == -1 {
+			break
+		}
+		end := i + j + 1 // just past the '>'
+		i = end
+
+		if end+1 < len(sc.s) && sc.s[end+1] != ' ' {
+			continue
+		}
+		if d, ok := splitPlayer(sc.s[start:end]); ok {
+			sc.pos = end + 1
+			return d, true
+		}
+	}
+
+	return PlayerData{}, false
+}
+
+// splitPlayer splits name<uid><steamid><team>
+func splitPlayer(s string) (PlayerData, bool) {
+	var d PlayerData
+	if len(s) == 0 || s[len(s)-1] != '>' {
+		return d, false
+	}
+
+	i := strings.LastIndexByte(s, '<')
+	if i < 1 || s[i-1] != '>' {
+		return d, false
+	}
+	d.Team = s[i+1 : len(s)-1]
+	s = s[:i-1]
+
+	i = strings.LastIndexByte(s, '<')
+	if i < 1 || s[i-1] != '>' {
+		return d, false
+	}
+	steamID := s[i+1:]
+	s = s[:i-1]
+
+	i = strings.LastIndexByte(s, '<')
+	if i == -1 {
+		return d, false
+	}
+	d.UserId = s[i+1:]
+	d.Username = s[:i]
+
+	if !isDigits(d.UserId) || (d.Team != "" && !isWord(d.Team)) {
+		return d, false
+	}
+	var err error
+	d.SteamId, err = ParseSteamID(steamID)
+	return d, err == nil
+}
+
+// properties appends the (key "value") pairs that follow to props
+func (sc *logScanner) properties(props Properties) Properties {
+	for {
+		sc.skipSpaces()
+		rest := sc.rest()
+		if len(rest) == 0 || rest[0] != '(' {
+			return props
+		}
+
+		sp := strings.IndexByte(rest, ' ')
+		if sp < 2 || sp+1 >= len(rest) || rest[sp+1] != '"' {
+			return props
+		}
+		value := rest[sp+2:]
+		end := strings.Index(value, `")`)
+		if end == -1 {
+			return props
+		}
+
+		props = append(props, Property{rest[1:sp], value[:end]})
+		sc.pos += sp + 2 + end + 2
+	}
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isWord reports whether s matches \w+
+func isWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}